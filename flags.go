@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flag describes a command line option. Options may have a short name, used
+// as -x, and a long name, used as --name. If arg is set, the option takes an
+// argument and arg is the name it's given in the help text.
+type flag struct {
+	short byte
+	long  string
+	arg   string
+	help  string
+	set   func(value string) error
+}
+
+// parseArgs parses argv according to flags, calling each flag's set function
+// as it is encountered, and returns the remaining operands. It accepts:
+//
+//   - long options, with arguments given as "--name=value" or "--name value"
+//   - short options, which can be combined, as in "-aA". A short option
+//     which takes an argument consumes the rest of the word, or the next
+//     word if there is nothing left, so "-w4" and "-w 4" are equivalent
+//   - "--", which ends option parsing so that every later word is an operand
+//   - "-", which is treated as an operand
+func parseArgs(flags []flag, argv []string) ([]string, error) {
+	var operands []string
+	for len(argv) > 0 {
+		arg := argv[0]
+		argv = argv[1:]
+
+		switch {
+		case arg == "--":
+			return append(operands, argv...), nil
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			f := findFlag(flags, func(f *flag) bool { return f.long == name })
+			if f == nil {
+				return nil, fmt.Errorf("unknown option --%s", name)
+			}
+			if f.arg == "" {
+				if hasValue {
+					return nil, fmt.Errorf("option --%s doesn't take an argument", name)
+				}
+				value = ""
+			} else if !hasValue {
+				if len(argv) == 0 {
+					return nil, fmt.Errorf("option --%s requires an argument", name)
+				}
+				value = argv[0]
+				argv = argv[1:]
+			}
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("invalid argument %q for --%s: %v", value, name, err)
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for i := 1; i < len(arg); i++ {
+				short := arg[i]
+				f := findFlag(flags, func(f *flag) bool { return f.short == short })
+				if f == nil {
+					return nil, fmt.Errorf("unknown option -%c", short)
+				}
+				if f.arg == "" {
+					if err := f.set(""); err != nil {
+						return nil, fmt.Errorf("-%c: %v", short, err)
+					}
+					continue
+				}
+
+				value := arg[i+1:]
+				if value == "" {
+					if len(argv) == 0 {
+						return nil, fmt.Errorf("option -%c requires an argument", short)
+					}
+					value = argv[0]
+					argv = argv[1:]
+				}
+				if err := f.set(value); err != nil {
+					return nil, fmt.Errorf("invalid argument %q for -%c: %v", value, short, err)
+				}
+				break
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return operands, nil
+}
+
+func findFlag(flags []flag, match func(f *flag) bool) *flag {
+	for i := range flags {
+		if match(&flags[i]) {
+			return &flags[i]
+		}
+	}
+	return nil
+}
+
+// boolFlag returns a set function that sets b to true
+func boolFlag(b *bool) func(string) error {
+	return func(string) error {
+		*b = true
+		return nil
+	}
+}
+
+// intFlag returns a set function that parses an integer of at least min
+// into n
+func intFlag(n *int, min int) func(string) error {
+	return func(value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		if i < min {
+			return fmt.Errorf("must be at least %d", min)
+		}
+		*n = i
+		return nil
+	}
+}
+
+// stringFlag returns a setter for an option that takes any string
+func stringFlag(s *string) func(string) error {
+	return func(value string) error {
+		*s = value
+		return nil
+	}
+}
+
+// durationFlag returns a setter for an option that takes a duration like
+// "500ms" or "2s". A plain number is a number of seconds.
+func durationFlag(d *time.Duration) func(string) error {
+	return func(value string) error {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			value = fmt.Sprintf("%gs", seconds)
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration like 500ms or 2s")
+		}
+		if parsed < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+		*d = parsed
+		return nil
+	}
+}
+
+// flagsHelp formats the help text for flags, in the style of a man page's
+// OPTIONS section
+func flagsHelp(flags []flag) string {
+	var b strings.Builder
+	for i, f := range flags {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		var names []string
+		if f.short != 0 {
+			names = append(names, "-"+string(f.short))
+		}
+		if f.long != "" {
+			names = append(names, "--"+f.long)
+		}
+		b.WriteString("    " + strings.Join(names, ", "))
+		if f.arg != "" {
+			if f.long != "" {
+				b.WriteString("=" + f.arg)
+			} else {
+				b.WriteString(" " + f.arg)
+			}
+		}
+		b.WriteString("\n")
+
+		for _, line := range wrap(f.help, 72) {
+			b.WriteString("        " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// wrap splits text into lines of at most width characters, breaking on
+// spaces. Words longer than width are put on a line of their own.
+func wrap(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
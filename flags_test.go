@@ -0,0 +1,121 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/llimllib/git-ls/gitls"
+)
+
+func TestParseArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		argv     []string
+		expected options
+		operands []string
+		err      string
+	}{
+		{
+			name:     "No arguments",
+			argv:     []string{},
+			expected: options{Options: gitls.Options{DiffWidth: 4}},
+		},
+		{
+			name:     "Long options and operands",
+			argv:     []string{"--blame", "src", "--diffWidth=8", "main.go"},
+			expected: options{Options: gitls.Options{DiffWidth: 8, Blame: true}},
+			operands: []string{"src", "main.go"},
+		},
+		{
+			name:     "Long option with a separate argument",
+			argv:     []string{"--diffWidth", "6"},
+			expected: options{Options: gitls.Options{DiffWidth: 6}},
+		},
+		{
+			name:     "Combined short options",
+			argv:     []string{"-as", "-w10"},
+			expected: options{Options: gitls.Options{DiffWidth: 10, All: true, Hidden: true, Size: true}},
+		},
+		{
+			name:     "Short option argument in the next word",
+			argv:     []string{"-sw", "2", "."},
+			expected: options{Options: gitls.Options{DiffWidth: 2, Size: true}},
+			operands: []string{"."},
+		},
+		{
+			name:     "Terminator",
+			argv:     []string{"-A", "--", "-a", "--blame"},
+			expected: options{Options: gitls.Options{DiffWidth: 4, Hidden: true}},
+			operands: []string{"-a", "--blame"},
+		},
+		{
+			name:     "String option",
+			argv:     []string{"--remote", "upstream", "-w3"},
+			expected: options{Options: gitls.Options{DiffWidth: 3}, remote: "upstream"},
+		},
+		{
+			name: "Unknown long option",
+			argv: []string{"--bogus"},
+			err:  "unknown option --bogus",
+		},
+		{
+			name: "Unknown short option",
+			argv: []string{"-aq"},
+			err:  "unknown option -q",
+		},
+		{
+			name: "Missing argument",
+			argv: []string{"--diffWidth"},
+			err:  "option --diffWidth requires an argument",
+		},
+		{
+			name: "Non-numeric argument",
+			argv: []string{"--diffWidth=wide"},
+			err:  `invalid argument "wide" for --diffWidth: must be a number`,
+		},
+		{
+			name: "Unexpected argument",
+			argv: []string{"--blame=yes"},
+			err:  "option --blame doesn't take an argument",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := options{Options: gitls.Options{DiffWidth: 4}}
+			operands, err := parseArgs(cliFlags(&opts), tc.argv)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("Expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if opts != tc.expected {
+				t.Errorf("Expected options %#v, got %#v", tc.expected, opts)
+			}
+			if !slices.Equal(operands, tc.operands) {
+				t.Errorf("Expected operands %v, got %v", tc.operands, operands)
+			}
+		})
+	}
+}
+
+func TestFlagsHelp(t *testing.T) {
+	var opts options
+	flags := cliFlags(&opts)
+	help := flagsHelp(flags)
+	for _, f := range flags {
+		if !strings.Contains(help, "--"+f.long) {
+			t.Errorf("Expected help to document --%s", f.long)
+		}
+	}
+	for _, line := range strings.Split(help, "\n") {
+		if len(line) > 80 {
+			t.Errorf("Help line is too long: %q", line)
+		}
+	}
+}
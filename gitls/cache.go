@@ -0,0 +1,181 @@
+package gitls
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// logCacheVersion is incremented whenever the format of the cache or of the
+// log output it stores changes, so that old caches are discarded
+const logCacheVersion = 1
+
+// logCacheFile is the name of the cache file within the git directory
+const logCacheFile = "git-ls-cache.json"
+
+// logCache is a persistent cache of gitLog's output for each path in a
+// repository, stored in the repository's git directory. The result of
+// `git log -1 -- <path>` only depends on the commit HEAD points to, so the
+// entries are valid as long as HEAD doesn't move. When HEAD moves forward,
+// only the entries for paths touched by the new commits are discarded.
+type logCache struct {
+	Version int    `json:"version"`
+	Commit  string `json:"commit"`
+	Tree    string `json:"tree"`
+	// Entries maps a path relative to the root of the repository to the
+	// output of gitLog for it
+	Entries map[string]string `json:"entries"`
+
+	path  string
+	dirty bool
+	// mu guards Entries and dirty, since lookups run concurrently
+	mu sync.Mutex
+}
+
+// loadLogCache reads the cache for the repository containing dir, and brings
+// it up to date with HEAD. It returns nil if the repository has no commits.
+func loadLogCache(dir string) *logCache {
+	out, err := gitCommand(dir, "rev-parse", "HEAD", "HEAD^{tree}").Output()
+	if err != nil {
+		return nil
+	}
+	head := strings.Fields(string(out))
+	if len(head) != 2 {
+		return nil
+	}
+
+	gitDir, err := GitDir(dir)
+	if err != nil {
+		return nil
+	}
+	cache := &logCache{path: filepath.Join(gitDir, logCacheFile)}
+	if contents, err := os.ReadFile(cache.path); err == nil {
+		if err := json.Unmarshal(contents, cache); err != nil || cache.Version != logCacheVersion {
+			cache.Entries = nil
+		}
+	}
+
+	switch {
+	case cache.Entries == nil:
+		cache.Entries = make(map[string]string)
+		cache.dirty = true
+	case cache.Commit == head[0] && cache.Tree == head[1]:
+		return cache
+	case isAncestor(dir, cache.Commit, head[0]):
+		invalidate(cache.Entries, gitChangedPaths(dir, cache.Commit, head[0]))
+		cache.dirty = true
+	default:
+		// HEAD moved somewhere unrelated, like another branch, so we can't
+		// tell which entries are still valid
+		clear(cache.Entries)
+		cache.dirty = true
+	}
+
+	cache.Version = logCacheVersion
+	cache.Commit = head[0]
+	cache.Tree = head[1]
+	return cache
+}
+
+// isAncestor returns true if the commit ancestor is an ancestor of commit
+func isAncestor(dir string, ancestor string, commit string) bool {
+	if ancestor == "" {
+		return false
+	}
+	return gitCommand(dir, "merge-base", "--is-ancestor", ancestor, commit).Run() == nil
+}
+
+// gitChangedPaths returns every path touched by a commit in from..to,
+// relative to the root of the repository. Merges are compared against each
+// of their parents, so changes made while resolving conflicts are included,
+// and renames are reported as a deletion and an addition, so that both sides
+// are included.
+func gitChangedPaths(dir string, from string, to string) []string {
+	cmd := gitCommand(dir, "log", "-m", "-z", "--name-only", "--no-renames", "--format=", from+".."+to)
+	out, err := cmd.Output()
+	if err != nil {
+		return []string{"."}
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// invalidate removes the entries for each changed path and each directory
+// containing it, since a directory's last commit is the last commit to touch
+// anything inside of it
+func invalidate(entries map[string]string, changed []string) {
+	for _, p := range changed {
+		for p != "." && p != "/" && p != "" {
+			delete(entries, p)
+			p = path.Dir(p)
+		}
+		delete(entries, ".")
+	}
+}
+
+// wrap returns a gitLog query that uses the cached output when it's present,
+// even if ctx is done, and otherwise calls gitLog and caches the result.
+// curdir is the path of the files' directory relative to the root of the
+// repository.
+func (c *logCache) wrap(curdir string, gitLog query) query {
+	return func(ctx context.Context, file *Entry) ([]byte, error) {
+		key := filepath.ToSlash(filepath.Join(curdir, file.entry.Name()))
+		// ".." may be outside of the repository
+		if key == ".." || strings.HasPrefix(key, "../") {
+			return gitLog(ctx, file)
+		}
+		c.mu.Lock()
+		cached, ok := c.Entries[key]
+		c.mu.Unlock()
+		if ok {
+			return []byte(cached), nil
+		}
+
+		out, err := gitLog(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.Entries[key] = string(out)
+		c.dirty = true
+		c.mu.Unlock()
+		return out, nil
+	}
+}
+
+// save writes the cache if it has changed. It's written to a temporary file
+// which is renamed into place, so that concurrent runs never see a partially
+// written cache. Failure to write the cache isn't an error, since it's just
+// an optimization.
+func (c *logCache) save() {
+	if !c.dirty {
+		return
+	}
+	contents, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), logCacheFile+".*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,152 @@
+package gitls
+
+import (
+	"context"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvalidate(t *testing.T) {
+	entries := map[string]string{
+		".":              "root",
+		"README.md":      "readme",
+		"src":            "src",
+		"src/main.go":    "main",
+		"src/util.go":    "util",
+		"src/lib":        "lib",
+		"src/lib/lib.go": "lib.go",
+		"docs":           "docs",
+	}
+	invalidate(entries, []string{"src/lib/lib.go", "new/file.txt"})
+
+	expected := map[string]string{
+		"README.md":   "readme",
+		"src/main.go": "main",
+		"src/util.go": "util",
+		"docs":        "docs",
+	}
+	if !maps.Equal(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+}
+
+func TestGitChangedPathsRename(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Author")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Committer")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := gitCommand(dir, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "-q", "-b", "main")
+	if err := os.MkdirAll(filepath.Join(dir, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "x", "a"), []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+	from := git("rev-parse", "HEAD")
+	if err := os.MkdirAll(filepath.Join(dir, "y"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git("mv", "x/a", "y/a")
+	git("commit", "-q", "-m", "move a out of x")
+
+	changed := gitChangedPaths(dir, from, "HEAD")
+	slices.Sort(changed)
+	if expected := []string{"x/a", "y/a"}; !slices.Equal(changed, expected) {
+		t.Errorf("Expected the changed paths %v, got %v", expected, changed)
+	}
+
+	// the directory the file was moved out of has a new last commit too
+	entries := map[string]string{".": "root", "x": "x", "x/b": "b", "z": "z"}
+	invalidate(entries, changed)
+	if expected := map[string]string{"x/b": "b", "z": "z"}; !maps.Equal(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+}
+
+func TestLogCacheWrap(t *testing.T) {
+	calls := 0
+	gitLog := func(ctx context.Context, file *Entry) ([]byte, error) {
+		calls++
+		return mockGitLog(ctx, file)
+	}
+
+	cache := &logCache{Entries: map[string]string{"sub/file2.go": "cached"}}
+	cached := cache.wrap("sub", gitLog)
+
+	ctx := context.Background()
+	if out, _ := cached(ctx, &Entry{entry: &mockDirEntry{name: "file2.go"}}); string(out) != "cached" || calls != 0 {
+		t.Errorf("Expected the cached entry, got %q after %d calls", out, calls)
+	}
+	expected, _ := mockGitLog(ctx, &Entry{entry: &mockDirEntry{name: "file1.go"}})
+	if out, _ := cached(ctx, &Entry{entry: &mockDirEntry{name: "file1.go"}}); string(out) != string(expected) || calls != 1 {
+		t.Errorf("Expected gitLog's output, got %q after %d calls", out, calls)
+	}
+	if _, ok := cache.Entries["sub/file1.go"]; !ok || !cache.dirty {
+		t.Errorf("Expected sub/file1.go to be cached")
+	}
+	cached(ctx, &Entry{entry: &mockDirEntry{name: "file1.go"}})
+	if calls != 1 {
+		t.Errorf("Expected the second lookup to be cached, but gitLog was called %d times", calls)
+	}
+
+	// lookups that time out aren't cached
+	if _, err := withTimeout(time.Millisecond, cached)(ctx, &Entry{entry: &mockDirEntry{name: "slow.go"}}); err == nil {
+		t.Errorf("Expected slow.go's lookup to time out")
+	}
+	if _, ok := cache.Entries["sub/slow.go"]; ok {
+		t.Errorf("Expected sub/slow.go not to be cached")
+	}
+}
+
+// wrappedGit is a Repository which wraps Git and replaces its history, the
+// way a caller might to mock or filter it
+type wrappedGit struct {
+	Git
+}
+
+func (wrappedGit) Log(ctx context.Context, file *Entry) ([]byte, error) {
+	return []byte("abc1234\x002024-01-02\x00Wrapper\x00wrapper@example.com\x00From the wrapper"), nil
+}
+
+func TestWrappedLog(t *testing.T) {
+	dir := testRepo(t)
+
+	// Git fills the cache, which the wrapper mustn't read from
+	if _, err := List(dir, Options{Repository: Git{}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range []Repository{wrappedGit{}, &wrappedGit{}} {
+		entries, err := List(dir, Options{Repository: repo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.Name() != ".." && entry.Message() != "From the wrapper" {
+				t.Errorf("Expected %s's message to come from the wrapper, got %q", entry.Name(), entry.Message())
+			}
+		}
+	}
+}
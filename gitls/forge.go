@@ -0,0 +1,278 @@
+package gitls
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// the kinds of links a forge can provide
+const (
+	forgeCommit = "commit"
+	forgeAuthor = "author"
+	forgeIssue  = "issue"
+	forgePR     = "pr"
+	forgeFile   = "file"
+	forgeDir    = "dir"
+)
+
+// githubTemplates are the links for repositories hosted on github.com
+var githubTemplates = map[string]string{
+	forgeCommit: "https://{host}/{owner}/{repo}/commit/{hash}",
+	forgeAuthor: "https://{host}/{owner}/{repo}/commits?author={email}",
+	forgeIssue:  "https://{host}/{owner}/{repo}/issues/{number}",
+	forgePR:     "https://{host}/{owner}/{repo}/pull/{number}",
+	forgeFile:   "https://{host}/{owner}/{repo}/blob/{rev}/{path}",
+	forgeDir:    "https://{host}/{owner}/{repo}/tree/{rev}/{path}",
+}
+
+// Forge is the site hosting a repository, like GitHub or a self-hosted
+// GitLab, which we link commits, authors and issues to
+type Forge struct {
+	Host  string
+	Owner string
+	Repo  string
+	// templates maps each kind of link to a URL template, in which {host},
+	// {owner} and {repo} are replaced by the forge's fields, and the other
+	// placeholders by the values for the link
+	templates map[string]string
+	// linkIssues makes #<number> references link to issues rather than
+	// pull requests
+	linkIssues bool
+}
+
+// FindForge returns the forge hosting the given remote of the repository
+// containing dir, or nil if it isn't on a forge we know how to link to.
+// github.com is known, and the links for any other host are configured with
+// git-ls.forge.<host>.<kind>, where kind is one of commit, author, issue, pr,
+// file or dir. The config options override the links for github.com too.
+//
+// If remote is "", the remote is the one set with git-ls.remote. If that isn't
+// set either, it's the first remote with links out of the current branch's
+// upstream remote, "upstream", "origin" and then the rest in order.
+//
+// On github.com, references to #<number> link to pull requests, unless
+// git-ls.linkIssues is true, in which case they link to issues. Other forges,
+// like GitLab, write merge requests as !<number>, so #<number> links to
+// issues there.
+func FindForge(repo Repository, dir string, remote string) (*Forge, error) {
+	remotes, err := repo.Remotes(dir)
+	if err != nil {
+		return nil, err
+	}
+	config, err := repo.Config(dir, `^(git-ls\.(forge\.|linkissues$|remote$)|branch\..*\.remote$)`)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := repo.Branch(dir)
+	if err != nil {
+		return nil, err
+	}
+	return findForge(remotes, parseConfig(config), branch, remote)
+}
+
+// findForge returns the forge of the remote to link to out of the output of
+// `git remote -v`, given the config options and the current branch
+func findForge(remotes []byte, config map[string]string, branch string, remote string) (*Forge, error) {
+	urls := make(map[string]string)
+	var names []string
+	for _, line := range strings.Split(string(remotes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if _, ok := urls[fields[0]]; !ok {
+			urls[fields[0]] = fields[1]
+			names = append(names, fields[0])
+		}
+	}
+
+	if remote == "" {
+		remote = config["git-ls.remote"]
+	}
+	if remote != "" {
+		url, ok := urls[remote]
+		if !ok {
+			return nil, fmt.Errorf("no remote named %s", remote)
+		}
+		return newForge(url, config), nil
+	}
+
+	preferred := []string{"upstream", "origin"}
+	if upstream := config["branch."+branch+".remote"]; upstream != "" {
+		preferred = slices.Insert(preferred, 0, upstream)
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return slices.Contains(preferred, name) })
+	for _, name := range append(preferred, names...) {
+		if url, ok := urls[name]; ok {
+			if forge := newForge(url, config); forge != nil {
+				return forge, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// newForge returns the forge for a remote's URL, or nil if it's not on a
+// host which has links
+func newForge(url string, config map[string]string) *Forge {
+	forge := parseRemoteURL(url)
+	if forge == nil {
+		return nil
+	}
+	forge.templates = forgeTemplates(forge.Host, config)
+	forge.linkIssues = configBool(config, "git-ls.linkissues") || forge.Host != "github.com"
+	if len(forge.templates) == 0 {
+		return nil
+	}
+	return forge
+}
+
+// forgeTemplates returns the link templates for host
+func forgeTemplates(host string, config map[string]string) map[string]string {
+	templates := make(map[string]string)
+	if host == "github.com" {
+		for kind, template := range githubTemplates {
+			templates[kind] = template
+		}
+	}
+	for _, kind := range []string{forgeCommit, forgeAuthor, forgeIssue, forgePR, forgeFile, forgeDir} {
+		if template, ok := config["git-ls.forge."+host+"."+kind]; ok {
+			templates[kind] = template
+		}
+	}
+	return templates
+}
+
+// remoteURLRe matches the URLs git accepts for a remote: a URL with a
+// scheme, like https://host/owner/repo or ssh://git@host:22/owner/repo, or
+// the scp-like form user@host:owner/repo
+var remoteURLRe = regexp.MustCompile(`^(?:[a-z+]+://(?:[^@/]+@)?([^/:]+)(?::\d+)?/|(?:[^@/]+@)?([^/:]+):)(.+)$`)
+
+// parseRemoteURL returns the host, owner and repository name of a remote's
+// URL, or nil if it isn't a URL on a host. The owner is everything before
+// the repository's name, so it may include GitLab subgroups.
+func parseRemoteURL(url string) *Forge {
+	matches := remoteURLRe.FindStringSubmatch(url)
+	if matches == nil {
+		return nil
+	}
+	host := matches[1] + matches[2]
+	path := strings.TrimSuffix(strings.Trim(matches[3], "/"), ".git")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 || slash == len(path)-1 {
+		return nil
+	}
+	return &Forge{Host: host, Owner: path[:slash], Repo: path[slash+1:]}
+}
+
+// link fills in the template for the kind of link with the forge's fields
+// and the given replacements, which alternate between placeholders and their
+// values. It returns "" if the forge has no template for kind.
+func (f *Forge) link(kind string, replacements ...string) string {
+	if f == nil || f.templates[kind] == "" {
+		return ""
+	}
+	replacements = append(replacements, "{host}", f.Host, "{owner}", f.Owner, "{repo}", f.Repo)
+	return strings.NewReplacer(replacements...).Replace(f.templates[kind])
+}
+
+// CommitURL returns the URL of the commit with the given hash
+func (f *Forge) CommitURL(hash string) string {
+	return f.link(forgeCommit, "{hash}", hash)
+}
+
+// AuthorURL returns the URL of the commits by the author with the given
+// email address
+func (f *Forge) AuthorURL(email string) string {
+	return f.link(forgeAuthor, "{email}", email)
+}
+
+// IssueURL returns the URL of the issue with the given number
+func (f *Forge) IssueURL(number string) string {
+	return f.link(forgeIssue, "{number}", number)
+}
+
+// PullURL returns the URL of the pull request with the given number
+func (f *Forge) PullURL(number string) string {
+	return f.link(forgePR, "{number}", number)
+}
+
+// referenceURL returns the URL of the issue or pull request with the given
+// number in the repository owner/repo on the same forge. If the forge has no
+// template for the kind of link, it uses the other kind.
+func (f *Forge) referenceURL(kind, owner, repo, number string) string {
+	other := forgeIssue
+	if kind == forgeIssue {
+		other = forgePR
+	}
+	for _, kind := range []string{kind, other} {
+		if url := f.link(kind, "{owner}", owner, "{repo}", repo, "{number}", number); url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+// forgeReferenceRe matches references to issues and pull requests: #<n> or
+// GH-<n> in the same repository, <owner>/<repo>#<n> in another one, and !<n>
+// for merge requests. The reference is the first group, since it must not
+// follow a word.
+var forgeReferenceRe = regexp.MustCompile(`(?:^|[^\w/#!.-])((?:([\w.-]+)/([\w.-]+))?([#!])(\d+)|GH-(\d+))\b`)
+
+// forgeReferences returns the references to issues and pull requests in msg,
+// linked to their pages on the forge
+func (f *Forge) forgeReferences(msg string) []reference {
+	if f == nil {
+		return nil
+	}
+	hashKind := forgePR
+	if f.linkIssues {
+		hashKind = forgeIssue
+	}
+
+	var found []reference
+	for _, match := range forgeReferenceRe.FindAllStringSubmatchIndex(msg, -1) {
+		group := func(i int) string {
+			if match[2*i] < 0 {
+				return ""
+			}
+			return msg[match[2*i]:match[2*i+1]]
+		}
+		owner, repo := f.Owner, f.Repo
+		if group(2) != "" {
+			owner, repo = group(2), group(3)
+		}
+
+		var url string
+		switch {
+		case group(6) != "":
+			url = f.referenceURL(hashKind, owner, repo, group(6))
+		case group(4) == "!":
+			url = f.link(forgePR, "{owner}", owner, "{repo}", repo, "{number}", group(5))
+		default:
+			url = f.referenceURL(hashKind, owner, repo, group(5))
+		}
+		if url != "" {
+			found = append(found, reference{start: match[2], end: match[3], url: url})
+		}
+	}
+	return found
+}
+
+// FileURL returns the URL of the file at path, relative to the root of the
+// repository, as it is at rev
+func (f *Forge) FileURL(rev, path string) string {
+	return f.link(forgeFile, "{rev}", rev, "{path}", path)
+}
+
+// DirURL returns the URL of the directory at path, relative to the root of
+// the repository, as it is at rev. If the forge has no template for
+// directories, it's the same as FileURL.
+func (f *Forge) DirURL(rev, path string) string {
+	if url := f.link(forgeDir, "{rev}", rev, "{path}", path); url != "" {
+		return url
+	}
+	return f.FileURL(rev, path)
+}
@@ -0,0 +1,475 @@
+package gitls
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// gitCommand returns a command to run git with the given arguments in dir
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	return gitCommandContext(context.Background(), dir, args...)
+}
+
+// gitCommandContext returns a command like gitCommand which is killed if ctx
+// is done before it finishes
+func gitCommandContext(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// Remotes runs `git remote -v` in dir
+func (Git) Remotes(dir string) ([]byte, error) {
+	cmd := gitCommand(dir, "remote", "-v")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git remotes: %w", err)
+	}
+	return out, nil
+}
+
+// Config runs `git config -z --get-regexp pattern` in dir
+func (Git) Config(dir string, pattern string) ([]byte, error) {
+	cmd := gitCommand(dir, "config", "-z", "--get-regexp", pattern)
+	out, err := cmd.Output()
+	// git config exits with status 1 when nothing matches
+	if exitErr, ok := err.(*exec.ExitError); err != nil && (!ok || exitErr.ExitCode() != 1) {
+		return nil, fmt.Errorf("failed to read the git config: %w", err)
+	}
+	return out, nil
+}
+
+// configBool returns the value of a boolean option in config, which is
+// false if it isn't set
+func configBool(config map[string]string, key string) bool {
+	value, ok := config[key]
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(value) {
+	// an option without a value is true
+	case "", "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// parseConfig parses the output of `git config -z --get-regexp` into a map
+// of keys to values. If a key has several values, the last one wins, as it
+// does for git.
+func parseConfig(out []byte) map[string]string {
+	config := make(map[string]string)
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if key, value, _ := strings.Cut(entry, "\n"); key != "" {
+			config[key] = value
+		}
+	}
+	return config
+}
+
+// Branch runs `git rev-parse --abbrev-ref HEAD` in dir
+func (Git) Branch(dir string) (string, error) {
+	cmd := gitCommand(dir, "rev-parse", "--abbrev-ref", "HEAD")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the current branch: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Head runs `git rev-parse HEAD` in dir
+func (Git) Head(dir string) (string, error) {
+	cmd := gitCommand(dir, "rev-parse", "HEAD")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find HEAD: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Root runs `git rev-parse --show-toplevel` in dir
+func (Git) Root(dir string) (string, error) {
+	cmd := gitCommand(dir, "rev-parse", "--show-toplevel")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the root of the repository: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// CommonDir returns the path of the repository's common git directory,
+// which is shared by all worktrees
+func CommonDir(dir string) (string, error) {
+	cmd := gitCommand(dir, "rev-parse", "--git-common-dir")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the git directory: %w", err)
+	}
+	// the common dir may be relative to the directory git was run in
+	commonDir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(dir, commonDir)
+	}
+	return commonDir, nil
+}
+
+// GitDir returns the absolute path of the git directory for the repository
+// containing dir. In a worktree, this is the worktree's own git directory.
+func GitDir(dir string) (string, error) {
+	cmd := gitCommand(dir, "rev-parse", "--absolute-git-dir")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the git directory: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Status runs `git status --porcelain --ignored` in dir
+func (Git) Status(dir string) ([]byte, error) {
+	cmd := gitCommand(dir, "status", "--porcelain", "--ignored")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git status: %w", err)
+	}
+	return out, nil
+}
+
+func fileStatus(status []byte, files []*Entry, curdir string) {
+	gitStatusMap := make(map[string][]string)
+	lines := strings.Split(string(status), "\n")
+
+	for _, line := range lines {
+		if len(line) >= 3 {
+			status := line[:2]
+			// TODO: reject filenames that aren't in the current directory. Can
+			// we just ignore ".." entries? Right now, if you're in /subdir,
+			// and there's changes in /otherdir/whatever , this will create
+			// gitStatusMap entries of "..", which doesn't seem to mess stuff
+			// up but isn't ideal either
+			rel, err := filepath.Rel(curdir, line[3:])
+			if err != nil {
+				continue
+			}
+			fileName := first(rel)
+			if status == "!!" {
+				status = "I"
+			}
+			gitStatusMap[fileName] = append(gitStatusMap[fileName], status)
+		}
+	}
+
+	for _, file := range files {
+		// the current and parent directory entries aren't files that git
+		// reports a status for
+		if file.entry.Name() == "." || file.entry.Name() == ".." {
+			continue
+		}
+		if fileStatus, ok := gitStatusMap[file.entry.Name()]; ok {
+			slices.Sort(fileStatus)
+			file.status = strings.Join(slices.Compact(fileStatus), ",")
+		}
+		if file.entry.Name() == ".git" {
+			file.status = "*"
+		}
+	}
+}
+
+// Log runs `git log -1` for file, with a format of NUL-separated fields
+func (Git) Log(ctx context.Context, file *Entry) ([]byte, error) {
+	cmd := gitCommandContext(ctx, file.dir, "log", "-1", "--date=format:%Y-%m-%d",
+		"--pretty=format:%h%x00%ad%x00%aN%x00%aE%x00%s", "--", file.entry.Name())
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// at the root of the repository, ".." is outside of it and has no
+		// history
+		if file.entry.Name() == ".." {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get the history of %s: %w", file.FullPath(), err)
+	}
+	return out, nil
+}
+
+// parseGitLog looks up the last commit for each file, running up to jobs
+// lookups at a time. Files whose lookup didn't finish before ctx was done are
+// marked as timed out.
+func parseGitLog(ctx context.Context, files []*Entry, jobs int, gitLog query) error {
+	return forEach(files, jobs, func(file *Entry) error {
+		out, err := gitLog(ctx, file)
+		if timedOut(err) {
+			file.logTimedOut = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(out) == 0 {
+			return nil
+		}
+
+		parts := strings.SplitN(string(out), "\x00", 5)
+		if len(parts) != 5 {
+			return fmt.Errorf("unexpected git log output for %s: %q", file.FullPath(), out)
+		}
+
+		file.hash = parts[0]
+		file.lastModified = parts[1]
+		file.author = parts[2]
+		file.authorEmail = parts[3]
+		file.message = parts[4]
+		return nil
+	})
+}
+
+// Blame runs `git blame --porcelain` for file. It returns nothing if git
+// can't blame the file, for example because it's untracked.
+func (Git) Blame(ctx context.Context, file *Entry) ([]byte, error) {
+	cmd := gitCommandContext(ctx, file.dir, "blame", "--porcelain", "--", file.entry.Name())
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, ctx.Err()
+	}
+	return out, nil
+}
+
+// parseGitBlame finds the owner of each regular file, running up to jobs
+// blames at a time. Files whose blame didn't finish before ctx was done are
+// marked as timed out.
+func parseGitBlame(ctx context.Context, files []*Entry, jobs int, gitBlame query) error {
+	return forEach(files, jobs, func(file *Entry) error {
+		if file.isDir || !file.entry.Type().IsRegular() {
+			return nil
+		}
+		out, err := gitBlame(ctx, file)
+		if timedOut(err) {
+			file.blameTimedOut = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		file.blame = blameOwner(out)
+		return nil
+	})
+}
+
+// blameOwner parses the output of `git blame --porcelain` and returns the
+// author who owns the most lines of the file, or nil if the file has no
+// lines. In the porcelain format, each line of the file starts with a header
+// of "<hash> <orig line> <final line> [<group size>]", which is followed by
+// information about the commit the first time that commit is seen, and
+// finally by the line's contents prefixed with a tab.
+func blameOwner(porcelain []byte) *Blame {
+	authors := make(map[string]string)
+	counts := make(map[string]int)
+	total := 0
+
+	var commit string
+	for _, line := range strings.Split(string(porcelain), "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			counts[authors[commit]]++
+			total++
+		case strings.HasPrefix(line, "author "):
+			authors[commit] = line[len("author "):]
+		default:
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && len(fields[0]) >= 40 && isHex(fields[0]) {
+				commit = fields[0]
+			}
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	owner := ""
+	for author, count := range counts {
+		// break ties by name so that the output is stable
+		if count > counts[owner] || (count == counts[owner] && author < owner) {
+			owner = author
+		}
+	}
+
+	return &Blame{
+		Author:  owner,
+		Percent: (counts[owner]*100 + total/2) / total,
+	}
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// first returns the first part of a filepath. Given "some/file/path", it will
+// return "some". Modified from golang's built-in Split function:
+// https://github.com/golang/go/blob/c5698e315/src/internal/filepathlite/path.go#L204-L212
+func first(path string) string {
+	i := 0
+	for i < len(path) && !os.IsPathSeparator(path[i]) {
+		i++
+	}
+	return path[:i]
+}
+
+// diff returns an integer for +/-, or a literal '-' for a binary file. Return
+// 0 if the file was binary; binary files are counted separately by
+// parseDiffStat.
+func diffInt(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// NumStat runs `git diff --numstat --relative HEAD` in dir
+func (Git) NumStat(dir string) ([]byte, error) {
+	cmd := gitCommand(dir, "diff", "--numstat", "--relative", "HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the diffstat: %w", err)
+	}
+	return output, nil
+}
+
+// binaryPaths returns the paths of the binary files in numstat output, which
+// git marks with "-" instead of line counts
+func binaryPaths(diffStat []byte) []string {
+	var paths []string
+	lines := strings.Split(strings.TrimSpace(string(diffStat)), "\n")
+	for _, line := range lines {
+		parts := strings.Split(line, "\t")
+		if len(parts) >= 3 && parts[0] == "-" && parts[1] == "-" {
+			paths = append(paths, strings.TrimSpace(parts[2]))
+		}
+	}
+	return paths
+}
+
+// HeadSizes runs `git cat-file --batch-check` on each path in HEAD
+func (Git) HeadSizes(dir string, paths []string) ([]byte, error) {
+	var objects strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&objects, "HEAD:./%s\n", path)
+	}
+
+	cmd := gitCommand(dir, "cat-file", "--batch-check")
+	cmd.Stdin = strings.NewReader(objects.String())
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object sizes: %w", err)
+	}
+	return out, nil
+}
+
+// IndexModes runs `git ls-files --stage -z -- .` in dir
+func (Git) IndexModes(dir string) ([]byte, error) {
+	// list the whole directory rather than naming each file, which could
+	// run past the limit on the length of a command line
+	out, err := gitCommand(dir, "ls-files", "--stage", "-z", "--", ".").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get modes from the index: %w", err)
+	}
+	return out, nil
+}
+
+// parseBatchCheck parses the output of `git cat-file --batch-check`, which
+// has a line of "<oid> <type> <size>" for each object, or "<object> missing"
+// if the object doesn't exist. Missing objects have a size of 0.
+func parseBatchCheck(out []byte) []int64 {
+	var sizes []int64
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		var size int64
+		if len(fields) == 3 {
+			size, _ = strconv.ParseInt(fields[2], 10, 64)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// binarySizeDeltas returns the change in size between HEAD and the working
+// tree of each binary file in the diffstat, whose paths are relative to dir
+func binarySizeDeltas(repo Repository, dir string, diffStat []byte) (map[string]int64, error) {
+	paths := binaryPaths(diffStat)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	batchCheck, err := repo.HeadSizes(dir, paths)
+	if err != nil {
+		return nil, err
+	}
+	headSizes := parseBatchCheck(batchCheck)
+	deltas := make(map[string]int64, len(paths))
+	for i, path := range paths {
+		var size int64
+		if stat, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			size = stat.Size()
+		}
+		if i < len(headSizes) {
+			size -= headSizes[i]
+		}
+		deltas[path] = size
+	}
+	return deltas, nil
+}
+
+// parseDiffStat sums the numstat output for each file. sizeDeltas contains
+// the change in size of each binary file, by path.
+func parseDiffStat(diffStat []byte, files []*Entry, sizeDeltas map[string]int64) {
+	diffStats := make(map[string][]Diff)
+	lines := strings.Split(strings.TrimSpace(string(diffStat)), "\n")
+	for _, line := range lines {
+		parts := strings.Split(line, "\t")
+		if len(parts) < 3 {
+			continue
+		}
+
+		fullPath := strings.TrimSpace(parts[2])
+		diff := Diff{Plus: diffInt(parts[0]), Minus: diffInt(parts[1])}
+		if parts[0] == "-" && parts[1] == "-" {
+			diff.Binary = 1
+			diff.SizeDelta = sizeDeltas[fullPath]
+		}
+		path := first(fullPath)
+		diffStats[path] = append(diffStats[path], diff)
+	}
+
+	for _, file := range files {
+		// if the file has any diffs, sum them up. This way we aggregate a
+		// directory's diffs
+		if stats, ok := diffStats[file.entry.Name()]; ok {
+			sum := Diff{}
+			for _, stat := range stats {
+				sum.Plus += stat.Plus
+				sum.Minus += stat.Minus
+				sum.Binary += stat.Binary
+				sum.SizeDelta += stat.SizeDelta
+			}
+
+			file.diffSum = &sum
+		}
+	}
+}
@@ -0,0 +1,546 @@
+// Package gitls lists the files in a directory along with their git
+// information: their status, how much they've changed, and the last commit to
+// touch each of them. It's the core of the git-ls command, which prints the
+// listings with Render.
+package gitls
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Diff is the sum of the changes to a file, or to everything in a directory,
+// since HEAD
+type Diff struct {
+	Plus  int
+	Minus int
+	// numstat doesn't count lines for binary files, so we track how many
+	// binary files changed and their total change in size instead
+	Binary    int
+	SizeDelta int64
+}
+
+// Blame records which author owns the largest share of a file's current lines
+type Blame struct {
+	Author  string
+	Percent int
+}
+
+// LFS describes a file tracked by git LFS
+type LFS struct {
+	// Present is true if the object's content is available locally, and
+	// false if we only have the pointer file
+	Present bool
+	Size    int64
+}
+
+// Entry is a file or directory in a listing, along with its git information
+type Entry struct {
+	entry        os.DirEntry
+	status       string
+	diffSum      *Diff
+	diffStat     string
+	author       string
+	authorEmail  string
+	hash         string
+	lastModified string
+	message      string
+	blame        *Blame
+	lfs          *LFS
+	stat         *Stat
+	size         string
+	lines        string
+	isDir        bool
+	isExe        bool
+	// dir is the absolute path of the directory containing the file, and
+	// path is the path it was given as to ListFiles, if it was
+	dir  string
+	path string
+	// logTimedOut and blameTimedOut are set if looking up the file's last
+	// commit or its blame didn't finish in time
+	logTimedOut   bool
+	blameTimedOut bool
+}
+
+// Name returns the name of the entry within its directory
+func (f *Entry) Name() string {
+	return f.entry.Name()
+}
+
+// Dir returns the absolute path of the directory containing the entry
+func (f *Entry) Dir() string {
+	return f.dir
+}
+
+// FullPath returns the absolute path of the file
+func (f *Entry) FullPath() string {
+	return filepath.Join(f.dir, f.entry.Name())
+}
+
+// DisplayName returns the name to show for the file: the path it was given
+// as to ListFiles, or otherwise its name
+func (f *Entry) DisplayName() string {
+	if f.path != "" {
+		return f.path
+	}
+	return f.entry.Name()
+}
+
+// IsDir returns true if the entry is a directory
+func (f *Entry) IsDir() bool {
+	return f.isDir
+}
+
+// IsExecutable returns true if the entry is an executable file
+func (f *Entry) IsExecutable() bool {
+	return f.isExe
+}
+
+// Status returns the entry's status in the format of `git status
+// --porcelain`, like "M " or "??". A directory has the statuses of the files
+// within it, separated by commas. Ignored files are "I", and the .git
+// directory is "*".
+func (f *Entry) Status() string {
+	return f.status
+}
+
+// Diff returns the changes to the entry since HEAD, or nil if it hasn't
+// changed
+func (f *Entry) Diff() *Diff {
+	return f.diffSum
+}
+
+// Hash returns the abbreviated hash of the last commit to touch the entry, or
+// "" if no commit has
+func (f *Entry) Hash() string {
+	return f.hash
+}
+
+// Date returns the author date of the last commit to touch the entry, in the
+// form 2006-01-02
+func (f *Entry) Date() string {
+	return f.lastModified
+}
+
+// Author returns the author of the last commit to touch the entry
+func (f *Entry) Author() string {
+	return f.author
+}
+
+// AuthorEmail returns the email of the author of the last commit to touch
+// the entry
+func (f *Entry) AuthorEmail() string {
+	return f.authorEmail
+}
+
+// Message returns the subject of the last commit to touch the entry
+func (f *Entry) Message() string {
+	return f.message
+}
+
+// Blame returns the author who owns the largest share of the file's lines,
+// or nil if it wasn't requested or the file isn't tracked
+func (f *Entry) Blame() *Blame {
+	return f.blame
+}
+
+// LFS returns the file's LFS information, or nil if it isn't tracked by LFS
+func (f *Entry) LFS() *LFS {
+	return f.lfs
+}
+
+// Stat returns the file's information from the file system, or nil if a
+// long listing wasn't requested
+func (f *Entry) Stat() *Stat {
+	return f.stat
+}
+
+// Size returns the human-readable size of the file, if it was requested
+func (f *Entry) Size() string {
+	return f.size
+}
+
+// Lines returns the number of lines in the file, or "-" if it's binary, if
+// they were requested
+func (f *Entry) Lines() string {
+	return f.lines
+}
+
+// TimedOut returns true if looking up the entry's last commit or its blame
+// didn't finish before the time limit
+func (f *Entry) TimedOut() bool {
+	return f.logTimedOut || f.blameTimedOut
+}
+
+// Options controls what a listing includes
+type Options struct {
+	// DiffWidth is the width of the diffstat graph
+	DiffWidth int
+	Blame     bool
+	Size      bool
+	TotalSize bool
+	Lines     bool
+	// Long includes the file system information shown by `ls -l`, and the
+	// mode git records for each file
+	Long bool
+	// All includes the "." and ".." entries, and Hidden shows dotfiles
+	All    bool
+	Hidden bool
+	// Jobs is the maximum number of per-file git commands to run at once.
+	// Zero means the number of CPUs.
+	Jobs int
+	// NoCache disables the persistent cache of each file's last commit
+	NoCache bool
+	// Timeout limits the time spent looking up the history of all the files
+	// in a listing, and QueryTimeout the time spent on each one. Zero means
+	// no limit.
+	Timeout      time.Duration
+	QueryTimeout time.Duration
+	// Repository is where the git information comes from. If it's nil, the
+	// listing runs git.
+	Repository Repository
+}
+
+// defaultDiffWidth is the width of the diffstat graph if none is given
+const defaultDiffWidth = 4
+
+func (opts Options) withDefaults() Options {
+	if opts.DiffWidth <= 0 {
+		opts.DiffWidth = defaultDiffWidth
+	}
+	if opts.Jobs <= 0 {
+		opts.Jobs = runtime.NumCPU()
+	}
+	if opts.Repository == nil {
+		opts.Repository = Git{}
+	}
+	return opts
+}
+
+// newFile creates an Entry for a directory entry within dir, which must be an
+// absolute path
+func newFile(dir string, entry os.DirEntry) *Entry {
+	file := &Entry{
+		entry: entry,
+		dir:   dir,
+		isDir: entry.IsDir(),
+	}
+	if stat, err := os.Stat(file.FullPath()); err == nil {
+		file.isExe = !file.isDir && stat.Mode()&0111 != 0
+	}
+	return file
+}
+
+// List returns the contents of dir, annotated with their git information
+func List(dir string, opts Options) ([]Entry, error) {
+	return ListContext(context.Background(), dir, opts)
+}
+
+// ListContext is like List, but abandons the history lookups that haven't
+// finished when ctx is done, and marks those entries as timed out
+func ListContext(ctx context.Context, dir string, opts Options) ([]Entry, error) {
+	opts = opts.withDefaults()
+	ctx, cancel := timeoutContext(ctx, opts.Timeout)
+	defer cancel()
+
+	osfiles, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.All {
+		osfiles = append(dotEntries(dir), osfiles...)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*Entry
+	for _, file := range osfiles {
+		files = append(files, newFile(absDir, file))
+	}
+
+	files, err = annotate(ctx, absDir, files, opts)
+	if err != nil {
+		return nil, err
+	}
+	return values(files), nil
+}
+
+// ListFiles returns the given files annotated with their git information.
+// Files are annotated in groups by the directory they're in, but are
+// returned in the order they were given.
+func ListFiles(paths []string, opts Options) ([]Entry, error) {
+	return ListFilesContext(context.Background(), paths, opts)
+}
+
+// ListFilesContext is like ListFiles, but abandons the history lookups that
+// haven't finished when ctx is done, and marks those entries as timed out
+func ListFilesContext(ctx context.Context, paths []string, opts Options) ([]Entry, error) {
+	opts = opts.withDefaults()
+	ctx, cancel := timeoutContext(ctx, opts.Timeout)
+	defer cancel()
+
+	var files []*Entry
+	var dirs []string
+	groups := make(map[string][]*Entry)
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		file := newFile(dir, fs.FileInfoToDirEntry(stat))
+		file.path = path
+		files = append(files, file)
+
+		if _, ok := groups[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		groups[dir] = append(groups[dir], file)
+	}
+
+	// files that were explicitly asked for are shown even if they're hidden
+	opts.Hidden = true
+	for _, dir := range dirs {
+		if _, err := annotate(ctx, dir, groups[dir], opts); err != nil {
+			return nil, err
+		}
+	}
+	return values(files), nil
+}
+
+func values(files []*Entry) []Entry {
+	entries := make([]Entry, len(files))
+	for i, file := range files {
+		entries[i] = *file
+	}
+	return entries
+}
+
+// annotate adds git information to files, which must all be within dir, and
+// returns the files which should be shown
+func annotate(ctx context.Context, dir string, files []*Entry, opts Options) ([]*Entry, error) {
+	// the queries about the whole directory don't depend on each other, so
+	// run them all at once
+	repo := opts.Repository
+	var status, diffStat []byte
+	var statusErr, diffStatErr error
+	var cache *logCache
+	var hist *history
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		status, statusErr = repo.Status(dir)
+	}()
+	go func() {
+		defer wg.Done()
+		diffStat, diffStatErr = repo.NumStat(dir)
+	}()
+	// the cache and the history walker read the git directory and run git
+	// themselves, so they can only stand in for the git command, and not for
+	// a Repository which wraps it and might change its output
+	isGit := false
+	switch repo.(type) {
+	case Git, *Git:
+		isGit = true
+	}
+	go func() {
+		defer wg.Done()
+		if isGit && !opts.NoCache {
+			cache = loadLogCache(dir)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		// if the history can't be read in-process, we run git log instead
+		if isGit {
+			hist, _ = openHistory(dir)
+		}
+	}()
+	root, rootErr := repo.Root(dir)
+	wg.Wait()
+	if hist != nil {
+		defer hist.close()
+	}
+	if err := errors.Join(rootErr, statusErr, diffStatErr); err != nil {
+		return nil, err
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	curdir, err := filepath.Rel(root, realDir)
+	if err != nil {
+		return nil, err
+	}
+	fileStatus(status, files, curdir)
+	if !opts.Hidden {
+		files = hideDotfiles(files)
+	}
+
+	// each of these sets different fields of the files, so they can run
+	// concurrently with the per-file log and blame lookups
+	var diffErr, lfsErr, statErr error
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		var deltas map[string]int64
+		if deltas, diffErr = binarySizeDeltas(repo, dir, diffStat); diffErr == nil {
+			parseDiffStat(diffStat, files, deltas)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		lfsErr = annotateLFS(repo, dir, files)
+	}()
+	go func() {
+		defer wg.Done()
+		if opts.Long {
+			statErr = annotateStat(repo, dir, files)
+		}
+	}()
+
+	lookup := query(repo.Log)
+	if hist != nil {
+		lookup = hist.wrap(curdir, repo.Log)
+	}
+	if cache != nil {
+		lookup = cache.wrap(curdir, lookup)
+		defer cache.save()
+	}
+	logErr := parseGitLog(ctx, files, opts.Jobs, withTimeout(opts.QueryTimeout, lookup))
+	var blameErr error
+	if opts.Blame {
+		blameErr = parseGitBlame(ctx, files, opts.Jobs, withTimeout(opts.QueryTimeout, repo.Blame))
+	}
+	if opts.Size {
+		fileSizes(files, opts.TotalSize)
+	}
+	if opts.Lines {
+		lineCounts(files)
+	}
+	wg.Wait()
+	if err := errors.Join(logErr, blameErr, diffErr, lfsErr, statErr); err != nil {
+		return nil, err
+	}
+
+	// generate a diffStat graph for every file
+	for _, file := range files {
+		file.diffStat = makeDiffGraph(file, opts.DiffWidth)
+	}
+	return files, nil
+}
+
+// query looks up something about a file with git. If ctx is done before the
+// lookup finishes, it returns ctx's error.
+type query func(ctx context.Context, file *Entry) ([]byte, error)
+
+// withTimeout returns a query that gives up on each lookup after d, or q
+// itself if d is zero
+func withTimeout(d time.Duration, q query) query {
+	if d == 0 {
+		return q
+	}
+	return func(ctx context.Context, file *Entry) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return q(ctx, file)
+	}
+}
+
+// timedOut returns true if err is the error of a context which was canceled
+// or whose deadline passed
+func timedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// timeoutContext returns a context that's done after d, or that's only done
+// when it's canceled if d is zero
+func timeoutContext(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// forEach calls fn for each file, using up to jobs goroutines at a time, and
+// returns once every call has finished. It returns the errors fn returned.
+func forEach(files []*Entry, jobs int, fn func(file *Entry) error) error {
+	work := make(chan *Entry)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for i := 0; i < max(1, min(jobs, len(files))); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range work {
+				if err := fn(file); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, file := range files {
+		work <- file
+	}
+	close(work)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// dotEntry is a directory entry for "." or "..", which os.ReadDir omits
+type dotEntry struct {
+	fs.DirEntry
+	name string
+}
+
+func (e dotEntry) Name() string {
+	return e.name
+}
+
+// dotEntries returns entries for dir and its parent directory
+func dotEntries(dir string) []os.DirEntry {
+	var entries []os.DirEntry
+	for _, name := range []string{".", ".."} {
+		if stat, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			entries = append(entries, dotEntry{fs.FileInfoToDirEntry(stat), name})
+		}
+	}
+	return entries
+}
+
+// hideDotfiles removes hidden files from the list of files, unless they have
+// a git status showing that they've changed. Ignored files don't count as
+// changed.
+func hideDotfiles(files []*Entry) []*Entry {
+	return slices.DeleteFunc(files, func(file *Entry) bool {
+		if !strings.HasPrefix(file.entry.Name(), ".") {
+			return false
+		}
+		for _, status := range strings.Split(file.status, ",") {
+			if status != "" && status != "I" && status != "*" {
+				return false
+			}
+		}
+		return true
+	})
+}
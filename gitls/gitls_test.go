@@ -0,0 +1,1548 @@
+package gitls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestFindForge(t *testing.T) {
+	config := map[string]string{
+		"git-ls.forge.git.corp.example.commit": "https://{host}/{owner}/{repo}/commit/{hash}",
+		"git-ls.forge.git.corp.example.issue":  "https://jira.corp.example/browse/{number}",
+	}
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{
+			name:     "Valid GitHub remote",
+			input:    []byte("origin\tgit@github.com:username/repo.git (fetch)\norigin\tgit@github.com:username/repo.git (push)"),
+			expected: "https://github.com/username/repo/commit/abc",
+		},
+		{
+			name:     "Valid GitHub remote with HTTP",
+			input:    []byte("origin\thttps://github.com/username/repo.git (fetch)\norigin\thttps://github.com/username/repo.git (push)"),
+			expected: "https://github.com/username/repo/commit/abc",
+		},
+		{
+			name:     "Unknown remote",
+			input:    []byte("origin\tgit@example.com:username/repo.git (fetch)\norigin\tgit@example.com:username/repo.git (push)"),
+			expected: "",
+		},
+		{
+			name:     "Configured remote",
+			input:    []byte("origin\tssh://git@git.corp.example:2222/team/sub/repo.git (fetch)"),
+			expected: "https://git.corp.example/team/sub/repo/commit/abc",
+		},
+		{
+			name:     "First remote with links",
+			input:    []byte("mirror\t/srv/git/repo.git (fetch)\norigin\tgit.corp.example:team/repo (fetch)"),
+			expected: "https://git.corp.example/team/repo/commit/abc",
+		},
+		{
+			name:     "Empty input",
+			input:    []byte{},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forge, _ := findForge(tt.input, config, "main", "")
+			if result := forge.CommitURL("abc"); result != tt.expected {
+				t.Errorf("findForge(%s) links to %q, expected %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+
+	forge, _ := findForge([]byte("origin\tgit@git.corp.example:team/repo.git (fetch)"), config, "main", "")
+	if url := forge.IssueURL("PROJ-12"); url != "https://jira.corp.example/browse/PROJ-12" {
+		t.Errorf("Unexpected issue URL %q", url)
+	}
+	if url := forge.AuthorURL("a@example.com"); url != "" {
+		t.Errorf("Expected no author URL, got %q", url)
+	}
+}
+
+func TestFindForgeRemote(t *testing.T) {
+	remotes := []byte("" +
+		"fork\tgit@github.com:me/repo.git (fetch)\n" +
+		"fork\tgit@github.com:me/repo.git (push)\n" +
+		"mirror\t/srv/git/repo.git (fetch)\n" +
+		"origin\tgit@github.com:team/repo.git (fetch)\n" +
+		"upstream\thttps://github.com/project/repo (fetch)\n")
+	testCases := []struct {
+		name     string
+		config   map[string]string
+		remote   string
+		expected string
+		err      string
+	}{
+		{
+			name:     "upstream before origin",
+			expected: "project",
+		},
+		{
+			name:     "The branch's upstream remote",
+			config:   map[string]string{"branch.main.remote": "origin"},
+			expected: "team",
+		},
+		{
+			name:     "Configured remote",
+			config:   map[string]string{"branch.main.remote": "origin", "git-ls.remote": "fork"},
+			expected: "me",
+		},
+		{
+			name:     "Given remote",
+			config:   map[string]string{"git-ls.remote": "origin"},
+			remote:   "fork",
+			expected: "me",
+		},
+		{
+			name:     "Remote without links",
+			remote:   "mirror",
+			expected: "",
+		},
+		{
+			name:   "Missing remote",
+			remote: "nope",
+			err:    "no remote named nope",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forge, err := findForge(remotes, tc.config, "main", tc.remote)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("Expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			owner := ""
+			if forge != nil {
+				owner = forge.Owner
+			}
+			if err != nil || owner != tc.expected {
+				t.Errorf("Expected owner %q, got %q (%v)", tc.expected, owner, err)
+			}
+		})
+	}
+}
+
+type mockDirEntry struct {
+	name string
+}
+
+func (m *mockDirEntry) Name() string {
+	return m.name
+}
+
+func (m *mockDirEntry) IsDir() bool {
+	return false
+}
+
+func (m *mockDirEntry) Type() os.FileMode {
+	return 0
+}
+
+func (m *mockDirEntry) Info() (os.FileInfo, error) {
+	return nil, nil
+}
+
+func TestFileStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		files    []*Entry
+		dir      string
+		expected []string
+	}{
+		{
+			name:     "empty status and files",
+			status:   "",
+			files:    []*Entry{},
+			expected: []string{},
+			dir:      "",
+		},
+		{
+			name:   "single file with modified status",
+			status: " M file.go",
+			files: []*Entry{
+				{entry: &mockDirEntry{name: "file.go"}},
+			},
+			expected: []string{" M"},
+			dir:      "",
+		},
+		{
+			name:   "multiple files with different statuses",
+			status: "M  file1.go\nA  file2.go\n!! ignored.go",
+			files: []*Entry{
+				{entry: &mockDirEntry{name: "file1.go"}},
+				{entry: &mockDirEntry{name: "file2.go"}},
+				{entry: &mockDirEntry{name: "ignored.go"}},
+			},
+			expected: []string{"M ", "A ", "I"},
+			dir:      "",
+		},
+		{
+			name:   ".git directory status",
+			status: "M  file1.go",
+			files: []*Entry{
+				{entry: &mockDirEntry{name: "file1.go"}},
+				{entry: &mockDirEntry{name: ".git"}},
+			},
+			expected: []string{"M ", "*"},
+			dir:      "",
+		},
+		{
+			name:   "subdirectory",
+			status: "M  homedir/file2.go",
+			files: []*Entry{
+				{entry: &mockDirEntry{name: "file2.go"}},
+			},
+			dir:      "homedir/",
+			expected: []string{"M "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileStatus([]byte(tt.status), tt.files, tt.dir)
+			for i, f := range tt.files {
+				if f.status != tt.expected[i] {
+					t.Errorf("expected %s for %s, got %s", tt.expected[i], f.entry.Name(), f.status)
+				}
+			}
+		})
+	}
+}
+
+func mockGitLog(ctx context.Context, file *Entry) ([]byte, error) {
+	switch file.entry.Name() {
+	case "file1.go":
+		return []byte("hash1\x002023-03-01\x00John Doe\x00john@example.com\x00Initial commit"), nil
+	case "file2.go":
+		return []byte("hash2\x002023-03-02\x00Jane Smith\x00jane@example.com\x00Add new feature"), nil
+	case "file3.go":
+		return []byte("hash3\x002023-03-03\x00Bob Johnson\x00bob@example.com\x00Fix a bug parsing '|' pipes"), nil
+	case "file4.go":
+		return []byte("invalid output format"), nil
+	case "slow.go":
+		// never finishes on its own
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, nil
+	}
+}
+
+// fakeRepo is an in-memory Repository which returns canned git output.
+// Paths are relative to root.
+type fakeRepo struct {
+	root    string
+	branch  string
+	head    string
+	remotes string
+	config  map[string]string
+	status  string
+	numStat string
+	logs    map[string]string
+	blames  map[string]string
+	lfs     map[string]bool
+	sizes   map[string]int64
+	modes   map[string]uint32
+	err     error
+}
+
+func (r *fakeRepo) Root(dir string) (string, error) {
+	return r.root, r.err
+}
+
+func (r *fakeRepo) Branch(dir string) (string, error) {
+	return r.branch, r.err
+}
+
+func (r *fakeRepo) Remotes(dir string) ([]byte, error) {
+	return []byte(r.remotes), r.err
+}
+
+func (r *fakeRepo) Head(dir string) (string, error) {
+	return r.head, r.err
+}
+
+func (r *fakeRepo) Config(dir string, pattern string) ([]byte, error) {
+	re := regexp.MustCompile(pattern)
+	var out strings.Builder
+	for key, value := range r.config {
+		if re.MatchString(key) {
+			fmt.Fprintf(&out, "%s\n%s\x00", key, value)
+		}
+	}
+	return []byte(out.String()), r.err
+}
+
+func (r *fakeRepo) Status(dir string) ([]byte, error) {
+	return []byte(r.status), r.err
+}
+
+func (r *fakeRepo) NumStat(dir string) ([]byte, error) {
+	return []byte(r.numStat), r.err
+}
+
+func (r *fakeRepo) path(dir, name string) string {
+	path, _ := filepath.Rel(r.root, filepath.Join(dir, name))
+	return filepath.ToSlash(path)
+}
+
+func (r *fakeRepo) Log(ctx context.Context, file *Entry) ([]byte, error) {
+	return []byte(r.logs[r.path(file.dir, file.entry.Name())]), nil
+}
+
+func (r *fakeRepo) Blame(ctx context.Context, file *Entry) ([]byte, error) {
+	return []byte(r.blames[r.path(file.dir, file.entry.Name())]), nil
+}
+
+func (r *fakeRepo) CheckAttr(dir string, paths []string) ([]byte, error) {
+	var out strings.Builder
+	for _, path := range paths {
+		value := "unspecified"
+		if r.lfs[r.path(dir, path)] {
+			value = "lfs"
+		}
+		fmt.Fprintf(&out, "%s\x00filter\x00%s\x00", path, value)
+	}
+	return []byte(out.String()), nil
+}
+
+func (r *fakeRepo) HeadSizes(dir string, paths []string) ([]byte, error) {
+	var out strings.Builder
+	for _, path := range paths {
+		if size, ok := r.sizes[r.path(dir, path)]; ok {
+			fmt.Fprintf(&out, "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 blob %d\n", size)
+		} else {
+			fmt.Fprintf(&out, "HEAD:./%s missing\n", path)
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+func (r *fakeRepo) IndexModes(dir string) ([]byte, error) {
+	var out strings.Builder
+	for path, mode := range r.modes {
+		path, err := filepath.Rel(dir, filepath.Join(r.root, path))
+		if err != nil || strings.HasPrefix(path, "..") {
+			continue
+		}
+		fmt.Fprintf(&out, "%o e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 0\t%s\x00", mode, filepath.ToSlash(path))
+	}
+	return []byte(out.String()), nil
+}
+
+func TestParseGitLog(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    []*Entry
+		expected [][]string
+		err      string
+	}{
+		{
+			name: "Valid git log output",
+			files: []*Entry{
+				{entry: &mockDirEntry{name: "file1.go"}},
+				{entry: &mockDirEntry{name: "file2.go"}},
+				{entry: &mockDirEntry{name: "file3.go"}},
+			},
+			expected: [][]string{
+				{"file1.go", "hash1", "2023-03-01", "John Doe", "john@example.com", "Initial commit"},
+				{"file2.go", "hash2", "2023-03-02", "Jane Smith", "jane@example.com", "Add new feature"},
+				{"file3.go", "hash3", "2023-03-03", "Bob Johnson", "bob@example.com", "Fix a bug parsing '|' pipes"},
+			},
+		},
+		{
+			name: "Invalid git log output",
+			files: []*Entry{
+				{entry: &mockDirEntry{name: "file4.go"}},
+			},
+			err: `unexpected git log output for file4.go: "invalid output format"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := parseGitLog(context.Background(), tc.files, 2, mockGitLog)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("Expected the error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, file := range tc.files {
+				expected := tc.expected[i]
+				if file.hash != expected[1] {
+					t.Errorf("Unexpected hash for file %s: got %s, want %s", file.entry.Name(), file.hash, expected[1])
+				}
+				if file.lastModified != expected[2] {
+					t.Errorf("Unexpected lastModified for file %s: got %s, want %s", file.entry.Name(), file.lastModified, expected[2])
+				}
+				if file.author != expected[3] {
+					t.Errorf("Unexpected author for file %s: got %s, want %s", file.entry.Name(), file.author, expected[3])
+				}
+				if file.authorEmail != expected[4] {
+					t.Errorf("Unexpected authorEmail for file %s: got %s, want %s", file.entry.Name(), file.authorEmail, expected[4])
+				}
+				if file.message != expected[5] {
+					t.Errorf("Unexpected message for file %s: got %s, want %s", file.entry.Name(), file.message, expected[5])
+				}
+			}
+		})
+	}
+}
+
+func TestParseGitLogTimeout(t *testing.T) {
+	files := []*Entry{
+		{entry: &mockDirEntry{name: "file1.go"}},
+		{entry: &mockDirEntry{name: "slow.go"}},
+	}
+	if err := parseGitLog(context.Background(), files, 2, withTimeout(10*time.Millisecond, mockGitLog)); err != nil {
+		t.Fatal(err)
+	}
+
+	if files[0].logTimedOut || files[0].hash != "hash1" {
+		t.Errorf("Expected file1.go's lookup to finish, got hash %q", files[0].hash)
+	}
+	if !files[1].logTimedOut {
+		t.Errorf("Expected slow.go's lookup to time out")
+	}
+
+	// once the overall time limit has passed, nothing more is looked up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	files = []*Entry{{entry: &mockDirEntry{name: "slow.go"}}}
+	if err := parseGitLog(ctx, files, 2, mockGitLog); err != nil {
+		t.Fatal(err)
+	}
+	if !files[0].logTimedOut {
+		t.Errorf("Expected slow.go's lookup to time out")
+	}
+}
+
+func TestLinkify(t *testing.T) {
+	testCases := []struct {
+		name     string
+		test     string
+		expected string
+	}{
+		{
+			name:     "Basic test",
+			test:     "Some message",
+			expected: Link("https://github.com/a/b/commit/123abc", "Some message"),
+		},
+		{
+			name: "One issue link",
+			test: "fixes issue (#17)",
+			expected: Link("https://github.com/a/b/commit/123abc", "fixes issue (") +
+				Link("https://github.com/a/b/pull/17", fmt.Sprintf("%s%s%s", BLUE, "#17", RESET)) +
+				Link("https://github.com/a/b/commit/123abc", ")"),
+		},
+		{
+			name: "Two issue links",
+			test: "fixes issue (#17) closes (#99)",
+			expected: Link("https://github.com/a/b/commit/123abc", "fixes issue (") +
+				Link("https://github.com/a/b/pull/17", fmt.Sprintf("%s%s%s", BLUE, "#17", RESET)) +
+				Link("https://github.com/a/b/commit/123abc", ") closes (") +
+				Link("https://github.com/a/b/pull/99", fmt.Sprintf("%s%s%s", BLUE, "#99", RESET)) +
+				Link("https://github.com/a/b/commit/123abc", ")"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forge, _ := findForge([]byte("origin\tgit@github.com:a/b.git (fetch)"), nil, "main", "")
+			s := linkify(tc.test, forge, nil, "123abc")
+			if s != tc.expected {
+				t.Errorf("Expected\n%#v !=\n%#v", tc.expected, s)
+			}
+		})
+	}
+}
+
+func TestLinkifyReferences(t *testing.T) {
+	references, err := parseReferences(map[string]string{
+		"git-ls.reference.jira.pattern":   `\b[A-Z]+-\d+\b`,
+		"git-ls.reference.jira.url":       "https://jira.example.com/browse/{0}",
+		"git-ls.reference.github.pattern": `\bGH-(\d+)\b`,
+		"git-ls.reference.github.url":     "https://{host}/{owner}/{repo}/issues/{1}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := func(url, text string) string {
+		return Link(url, fmt.Sprintf("%s%s%s", BLUE, text, RESET))
+	}
+
+	forge, _ := findForge([]byte("origin\tgit@github.com:a/b.git (fetch)"), nil, "main", "")
+	s := linkify("PROJ-12: fix GH-3 and #4", forge, references, "123abc")
+	expected := ref("https://jira.example.com/browse/PROJ-12", "PROJ-12") +
+		Link("https://github.com/a/b/commit/123abc", ": fix ") +
+		ref("https://github.com/a/b/issues/3", "GH-3") +
+		Link("https://github.com/a/b/commit/123abc", " and ") +
+		ref("https://github.com/a/b/pull/4", "#4")
+	if s != expected {
+		t.Errorf("Expected\n%#v !=\n%#v", expected, s)
+	}
+
+	// without a forge, only the references are linked
+	s = linkify("LIN-88 #4", nil, references, "123abc")
+	expected = ref("https://jira.example.com/browse/LIN-88", "LIN-88") + " #4"
+	if s != expected {
+		t.Errorf("Expected\n%#v !=\n%#v", expected, s)
+	}
+
+	if _, err := parseReferences(map[string]string{"git-ls.reference.bad.pattern": "(", "git-ls.reference.bad.url": "x"}); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+	if _, err := parseReferences(map[string]string{"git-ls.reference.nourl.pattern": "x"}); err == nil {
+		t.Errorf("Expected an error for a reference without a URL")
+	}
+}
+
+func TestForgeReferences(t *testing.T) {
+	github := []byte("origin\tgit@github.com:a/b.git (fetch)")
+	gitlab := []byte("origin\tgit@gitlab.example:group/project.git (fetch)")
+	gitlabConfig := map[string]string{
+		"git-ls.forge.gitlab.example.issue": "https://{host}/{owner}/{repo}/-/issues/{number}",
+		"git-ls.forge.gitlab.example.pr":    "https://{host}/{owner}/{repo}/-/merge_requests/{number}",
+	}
+	testCases := []struct {
+		name     string
+		remotes  []byte
+		config   map[string]string
+		msg      string
+		expected []string
+	}{
+		{
+			name:     "Pull requests by default",
+			remotes:  github,
+			msg:      "Fix (#12) and GH-13",
+			expected: []string{"#12 https://github.com/a/b/pull/12", "GH-13 https://github.com/a/b/pull/13"},
+		},
+		{
+			name:     "Issues",
+			remotes:  github,
+			config:   map[string]string{"git-ls.linkissues": "true"},
+			msg:      "Fix #12",
+			expected: []string{"#12 https://github.com/a/b/issues/12"},
+		},
+		{
+			name:     "Cross-repository",
+			remotes:  github,
+			msg:      "See llimllib/git-ls#7, and c.d/e_f#8",
+			expected: []string{"llimllib/git-ls#7 https://github.com/llimllib/git-ls/pull/7", "c.d/e_f#8 https://github.com/c.d/e_f/pull/8"},
+		},
+		{
+			name:     "Not references",
+			remotes:  github,
+			msg:      "C#12 a/b/c#3 foo#4 #5x",
+			expected: nil,
+		},
+		{
+			name:     "Merge requests",
+			remotes:  gitlab,
+			config:   gitlabConfig,
+			msg:      "Merge !4 for #5",
+			expected: []string{"!4 https://gitlab.example/group/project/-/merge_requests/4", "#5 https://gitlab.example/group/project/-/issues/5"},
+		},
+		{
+			name:    "Issues on a forge with only issues",
+			remotes: gitlab,
+			config: map[string]string{
+				"git-ls.forge.gitlab.example.issue": "https://{host}/{owner}/{repo}/-/issues/{number}",
+			},
+			msg:      "Fix #5 in !6",
+			expected: []string{"#5 https://gitlab.example/group/project/-/issues/5"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			forge, _ := findForge(tc.remotes, tc.config, "main", "")
+			var refs []string
+			for _, ref := range findReferences(tc.msg, nil, forge) {
+				refs = append(refs, tc.msg[ref.start:ref.end]+" "+ref.url)
+			}
+			if !slices.Equal(refs, tc.expected) {
+				t.Errorf("Expected %q, got %q", tc.expected, refs)
+			}
+		})
+	}
+}
+
+func TestBlameOwner(t *testing.T) {
+	porcelain := "" +
+		"1111111111111111111111111111111111111111 1 1 2\n" +
+		"author Alice\n" +
+		"author-mail <alice@example.com>\n" +
+		"summary Add a file\n" +
+		"filename file.go\n" +
+		"\tpackage main\n" +
+		"1111111111111111111111111111111111111111 2 2\n" +
+		"\t\n" +
+		"2222222222222222222222222222222222222222 3 3 1\n" +
+		"author Bob\n" +
+		"author-mail <bob@example.com>\n" +
+		"summary author lines look like headers 1 2\n" +
+		"previous 1111111111111111111111111111111111111111 file.go\n" +
+		"filename file.go\n" +
+		"\tfunc main() {}\n"
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected *Blame
+	}{
+		{
+			name:     "Empty output",
+			input:    "",
+			expected: nil,
+		},
+		{
+			name:     "Two authors",
+			input:    porcelain,
+			expected: &Blame{Author: "Alice", Percent: 67},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			blame := blameOwner([]byte(tc.input))
+			if tc.expected == nil {
+				if blame != nil {
+					t.Errorf("Expected nil, got %#v", blame)
+				}
+				return
+			}
+			if blame == nil || *blame != *tc.expected {
+				t.Errorf("Expected %#v, got %#v", tc.expected, blame)
+			}
+		})
+	}
+}
+
+func TestParseGitBlame(t *testing.T) {
+	errBlame := errors.New("fatal: bad revision")
+	gitBlame := func(ctx context.Context, file *Entry) ([]byte, error) {
+		switch file.entry.Name() {
+		case "file.go":
+			return []byte("1111111111111111111111111111111111111111 1 1 1\nauthor Alice\n\tpackage main\n"), nil
+		case "slow.go":
+			return nil, context.DeadlineExceeded
+		default:
+			return nil, errBlame
+		}
+	}
+	files := []*Entry{
+		{entry: &mockDirEntry{name: "file.go"}},
+		{entry: &mockDirEntry{name: "slow.go"}},
+		{entry: &mockDirEntry{name: "broken.go"}},
+	}
+
+	// a failure that isn't a timeout is an error, rather than shown as "…"
+	if err := parseGitBlame(context.Background(), files, 2, gitBlame); !errors.Is(err, errBlame) {
+		t.Errorf("Expected the blame's error, got %v", err)
+	}
+	if files[0].blame == nil || files[0].blame.Author != "Alice" {
+		t.Errorf("Expected file.go to be blamed on Alice, got %#v", files[0].blame)
+	}
+	if !files[1].blameTimedOut {
+		t.Errorf("Expected slow.go's blame to time out")
+	}
+	if files[2].blameTimedOut {
+		t.Errorf("Expected broken.go's blame not to be marked as timed out")
+	}
+}
+
+func TestLFSFiles(t *testing.T) {
+	attrs := "image.png\x00filter\x00lfs\x00main.go\x00filter\x00unspecified\x00model.bin\x00filter\x00lfs\x00"
+	lfs := lfsFiles([]byte(attrs))
+	if len(lfs) != 2 || !lfs["image.png"] || !lfs["model.bin"] {
+		t.Errorf("Unexpected LFS files: %v", lfs)
+	}
+	if len(lfsFiles(nil)) != 0 {
+		t.Errorf("Expected no LFS files for empty input")
+	}
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		oid      string
+		size     int64
+		expected bool
+	}{
+		{
+			name:     "Valid pointer",
+			input:    "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n",
+			oid:      "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393",
+			size:     12345,
+			expected: true,
+		},
+		{
+			name:     "Not a pointer",
+			input:    "\x89PNG\r\n",
+			expected: false,
+		},
+		{
+			name:     "Invalid size",
+			input:    "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a\nsize many\n",
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			oid, size, ok := parseLFSPointer([]byte(tc.input))
+			if ok != tc.expected || oid != tc.oid || size != tc.size {
+				t.Errorf("Expected (%q, %d, %v), got (%q, %d, %v)", tc.oid, tc.size, tc.expected, oid, size, ok)
+			}
+		})
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	testCases := []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0K"},
+		{1536, "1.5K"},
+		{12 * 1024, "12K"},
+		{5 * 1024 * 1024 * 1024, "5.0G"},
+		{-2048, "-2.0K"},
+	}
+	for _, tc := range testCases {
+		if s := humanSize(tc.input); s != tc.expected {
+			t.Errorf("humanSize(%d) = %q, expected %q", tc.input, s, tc.expected)
+		}
+	}
+}
+
+func TestParseDiffStat(t *testing.T) {
+	diffStat := "3\t1\tmain.go\n-\t-\timages/logo.png\n2\t0\timages/README.md\n-\t-\tfixture.bin\n"
+	deltas := map[string]int64{
+		"images/logo.png": 12 * 1024,
+		"fixture.bin":     -100,
+	}
+	if paths := binaryPaths([]byte(diffStat)); len(paths) != 2 || paths[0] != "images/logo.png" || paths[1] != "fixture.bin" {
+		t.Errorf("Unexpected binary paths: %v", paths)
+	}
+
+	files := []*Entry{
+		{entry: &mockDirEntry{name: "main.go"}},
+		{entry: &mockDirEntry{name: "images"}},
+		{entry: &mockDirEntry{name: "fixture.bin"}},
+		{entry: &mockDirEntry{name: "unchanged.go"}},
+	}
+	parseDiffStat([]byte(diffStat), files, deltas)
+
+	expected := []*Diff{
+		{Plus: 3, Minus: 1},
+		{Plus: 2, Binary: 1, SizeDelta: 12 * 1024},
+		{Binary: 1, SizeDelta: -100},
+		nil,
+	}
+	for i, file := range files {
+		if (file.diffSum == nil) != (expected[i] == nil) ||
+			(file.diffSum != nil && *file.diffSum != *expected[i]) {
+			t.Errorf("Unexpected diff for %s: got %#v, want %#v", file.entry.Name(), file.diffSum, expected[i])
+		}
+	}
+
+	graph := makeDiffGraph(files[1], 4)
+	if graph != fmt.Sprintf("%s++%s%s bin %s+12K%s", GREEN, RED, RESET, GREEN, RESET) {
+		t.Errorf("Unexpected graph %#v", graph)
+	}
+	graph = makeDiffGraph(files[2], 4)
+	if width(graph) != len("bin -100B") {
+		t.Errorf("Unexpected graph %#v", graph)
+	}
+}
+
+func TestWidth(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int
+	}{
+		{"main.go", 7},
+		{"café", 4},
+		{"café", 4},
+		{"日本語.txt", 10},
+		{"ｆｕｌｌ", 8},
+		{"한글", 4},
+		{"🎉 party", 8},
+		{"👩‍💻", 4},
+		{GREEN + "++" + RED + "-" + RESET, 3},
+		{Link("https://example.com/a", "日本"), 4},
+	}
+	for _, tc := range testCases {
+		if n := width(tc.input); n != tc.expected {
+			t.Errorf("width(%q) = %d, expected %d", tc.input, n, tc.expected)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	testCases := []struct {
+		input    string
+		width    int
+		expected string
+	}{
+		{"main.go", 10, "main.go"},
+		{"main.go", 4, "main"},
+		{"main.go", 0, ""},
+		{"日本語", 5, "日本"},
+		{"日本語", 4, "日本"},
+		{"café au lait", 4, "café"},
+		{"🎉🎉", 3, "🎉"},
+	}
+	for _, tc := range testCases {
+		if s := truncate(tc.input, tc.width); s != tc.expected {
+			t.Errorf("truncate(%q, %d) = %q, expected %q", tc.input, tc.width, s, tc.expected)
+		}
+	}
+}
+
+func TestRenderWide(t *testing.T) {
+	entries := []Entry{
+		{entry: &mockDirEntry{name: "日本語.txt"}},
+		{entry: &mockDirEntry{name: "🎉.md"}},
+		{entry: &mockDirEntry{name: "main.go"}},
+	}
+	for i := range entries {
+		entries[i].lastModified = "1 day ago"
+		entries[i].author = "Zoë"
+		entries[i].message = "修正: ファイル名の幅を数える"
+	}
+
+	var out strings.Builder
+	Render(&out, entries, RenderOptions{Width: 40})
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(entries) {
+		t.Fatalf("Expected %d lines, got %q", len(entries), out.String())
+	}
+	for _, line := range lines {
+		if !utf8.ValidString(line) {
+			t.Errorf("Line %q has a rune cut in half", line)
+		}
+		// every line has the same contents after the name, so they all
+		// take up the same width when the columns line up
+		if n := width(line); n != width(lines[0]) || n > 40 {
+			t.Errorf("Line %q is %d cells wide, expected %d", line, n, width(lines[0]))
+		}
+	}
+}
+
+func TestRenderTimedOutDate(t *testing.T) {
+	entries := []Entry{
+		{entry: &mockDirEntry{name: "main.go"}, lastModified: "2024-01-02"},
+		{entry: &mockDirEntry{name: "slow.go"}, logTimedOut: true},
+	}
+	for i := range entries {
+		entries[i].blame = &Blame{Author: "Alice", Percent: 100}
+	}
+
+	var out strings.Builder
+	Render(&out, entries, RenderOptions{Width: 80})
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(entries) {
+		t.Fatalf("Expected %d lines, got %q", len(entries), out.String())
+	}
+	// the blame lines up even though the date before it was cut short
+	if a, b := width(lines[0][:strings.Index(lines[0], "Alice")]), width(lines[1][:strings.Index(lines[1], "Alice")]); a != b {
+		t.Errorf("Expected the blame to line up, got\n%s", out.String())
+	}
+}
+
+func TestLayout(t *testing.T) {
+	columns := []column{
+		{width: 20, min: 8, priority: 4},
+		{width: 10, min: 10},
+		{width: 6, min: 4, priority: 3, optional: true},
+		{width: 30, min: 12, priority: 1, optional: true},
+	}
+	testCases := []struct {
+		maxWidth int
+		expected []int
+	}{
+		{80, []int{20, 10, 6, 30}},
+		{60, []int{20, 10, 6, 21}},
+		{50, []int{20, 10, 5, 12}},
+		{48, []int{19, 10, 4, 12}},
+		{40, []int{11, 10, 4, 12}},
+		{30, []int{14, 10, 4, 0}},
+		{20, []int{9, 10, 0, 0}},
+		{10, []int{8, 10, 0, 0}},
+	}
+	for _, tc := range testCases {
+		if widths := layout(columns, tc.maxWidth); !slices.Equal(widths, tc.expected) {
+			t.Errorf("layout(%d) = %v, expected %v", tc.maxWidth, widths, tc.expected)
+		}
+	}
+}
+
+func TestEllipsize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		width    int
+		expected string
+	}{
+		{"main.go", 7, "main.go"},
+		{"main.go", 6, "main.…"},
+		{"main.go", 1, "…"},
+		{"main.go", 0, ""},
+		{"日本語.txt", 6, "日本…"},
+		{"日本語.txt", 5, "日本…"},
+	}
+	for _, tc := range testCases {
+		if s := ellipsize(tc.input, tc.width); s != tc.expected {
+			t.Errorf("ellipsize(%q, %d) = %q, expected %q", tc.input, tc.width, s, tc.expected)
+		}
+	}
+}
+
+func TestWrapLine(t *testing.T) {
+	testCases := []struct {
+		input      string
+		width      int
+		line, rest string
+	}{
+		{"Fix the build", 20, "Fix the build", ""},
+		{"Fix the build", 10, "Fix the", "build"},
+		{"Fix the build", 7, "Fix the", "build"},
+		{"Refactoring", 4, "Refa", "ctoring"},
+		{"修正: ファイル名", 8, "修正:", "ファイル名"},
+	}
+	for _, tc := range testCases {
+		line, rest := wrapLine(tc.input, tc.width)
+		if line != tc.line || rest != tc.rest {
+			t.Errorf("wrapLine(%q, %d) = (%q, %q), expected (%q, %q)", tc.input, tc.width, line, rest, tc.line, tc.rest)
+		}
+	}
+}
+
+func TestRenderNarrow(t *testing.T) {
+	entries := []Entry{
+		{entry: &mockDirEntry{name: "a_rather_long_file_name.go"}},
+		{entry: &mockDirEntry{name: "main.go"}},
+	}
+	for i := range entries {
+		entries[i].lastModified = "2024-01-02"
+		entries[i].author = "Janet"
+		entries[i].message = "Split the renderer into columns with a layout"
+	}
+	plain := regexp.MustCompile("\x1b\\]8;;[^\x1b]*\x1b\\\\|\x1b\\[[0-9;]*m")
+
+	testCases := []struct {
+		name     string
+		opts     RenderOptions
+		expected string
+	}{
+		{
+			name: "Message cut short",
+			opts: RenderOptions{Width: 60},
+			expected: "" +
+				"a_rather_long_file_name.go 2024-01-02 Janet Split the rende…\n" +
+				"main.go                    2024-01-02 Janet Split the rende…\n",
+		},
+		{
+			name: "Name cut short",
+			opts: RenderOptions{Width: 40},
+			expected: "" +
+				"a_rather_l… 2024-01-02 Jan… Split the r…\n" +
+				"main.go     2024-01-02 Jan… Split the r…\n",
+		},
+		{
+			name: "Message wrapped",
+			opts: RenderOptions{Width: 60, Wrap: true},
+			expected: "" +
+				"a_rather_long_file_name.go 2024-01-02 Janet Split the\n" +
+				"                                            renderer into c…\n" +
+				"main.go                    2024-01-02 Janet Split the\n" +
+				"                                            renderer into c…\n",
+		},
+		{
+			name: "No width",
+			expected: "" +
+				"a_rather_long_file_name.go 2024-01-02\n" +
+				"main.go                    2024-01-02\n",
+		},
+		{
+			name: "Icons",
+			opts: RenderOptions{Width: 40, Icons: true},
+			expected: "" +
+				"\ue627 a_rather… 2024-01-02 Jan… Split the r…\n" +
+				"\ue627 main.go   2024-01-02 Jan… Split the r…\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			Render(&out, entries, tc.opts)
+			if s := plain.ReplaceAllString(out.String(), ""); s != tc.expected {
+				t.Errorf("Expected\n%s\ngot\n%s", tc.expected, s)
+			}
+		})
+	}
+}
+
+func TestIcon(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Makefile", "main.go", "README.MD", "notes"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"docs", ".github", "vendor/lib/.git"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("main.go", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("docs", filepath.Join(dir, "docs-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"Makefile":  fileIcons["makefile"],
+		"main.go":   extensionIcons["go"],
+		"README.MD": extensionIcons["md"],
+		"notes":     iconFile,
+		"run":       iconExecutable,
+		"docs":      iconDir,
+		".github":   dirIcons[".github"],
+		"vendor":    iconDir,
+		"link":      iconSymlink,
+		"docs-link": iconSymlinkDir,
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if s := icon(newFile(dir, entry)); s != expected[entry.Name()] {
+			t.Errorf("Expected icon %q for %s, got %q", expected[entry.Name()], entry.Name(), s)
+		}
+	}
+
+	entries, err = os.ReadDir(filepath.Join(dir, "vendor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := icon(newFile(filepath.Join(dir, "vendor"), entries[0])); s != iconSubmodule {
+		t.Errorf("Expected the submodule icon for vendor/lib, got %q", s)
+	}
+}
+
+func TestParseBatchCheck(t *testing.T) {
+	out := "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 blob 1234\nHEAD:./new.png missing\n"
+	sizes := parseBatchCheck([]byte(out))
+	if len(sizes) != 2 || sizes[0] != 1234 || sizes[1] != 0 {
+		t.Errorf("Unexpected sizes: %v", sizes)
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		lines    int
+		expected bool
+	}{
+		{"Empty file", "", 0, true},
+		{"Trailing newline", "one\ntwo\n", 2, true},
+		{"No trailing newline", "one\ntwo", 2, true},
+		{"Binary file", "\x89PNG\x00\x01", 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, ok := countLines(strings.NewReader(tc.input))
+			if n != tc.lines || ok != tc.expected {
+				t.Errorf("Expected (%d, %v), got (%d, %v)", tc.lines, tc.expected, n, ok)
+			}
+		})
+	}
+}
+
+func TestLineCounts(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{"main.go": "package main\n", "logo.png": "\x89PNG\x00"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("main.go", filepath.Join(dir, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*Entry
+	for _, entry := range entries {
+		files = append(files, newFile(dir, entry))
+	}
+
+	// opening the pipe would block until something writes to it
+	done := make(chan struct{})
+	go func() {
+		lineCounts(files)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lineCounts blocked")
+	}
+
+	expected := map[string]string{"main.go": "1", "logo.png": "-", "src": "", "link.go": "", "fifo": ""}
+	for _, file := range files {
+		if file.lines != expected[file.entry.Name()] {
+			t.Errorf("Expected %q lines for %s, got %q", expected[file.entry.Name()], file.entry.Name(), file.lines)
+		}
+	}
+}
+
+func TestHideDotfiles(t *testing.T) {
+	files := []*Entry{
+		{entry: &mockDirEntry{name: ".git"}, status: "*"},
+		{entry: &mockDirEntry{name: ".github"}},
+		{entry: &mockDirEntry{name: ".gitignore"}, status: " M"},
+		{entry: &mockDirEntry{name: ".DS_Store"}, status: "I"},
+		{entry: &mockDirEntry{name: ".env"}, status: "??"},
+		{entry: &mockDirEntry{name: "main.go"}},
+	}
+	expected := []string{".gitignore", ".env", "main.go"}
+
+	files = hideDotfiles(files)
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %d files, got %d", len(expected), len(files))
+	}
+	for i, file := range files {
+		if file.entry.Name() != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], file.entry.Name())
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var files []*Entry
+	for i := range 20 {
+		files = append(files, &Entry{entry: &mockDirEntry{name: fmt.Sprintf("file%d.go", i)}})
+	}
+
+	for _, jobs := range []int{0, 1, 3, 50} {
+		var mu sync.Mutex
+		running, most := 0, 0
+		seen := make(map[string]int)
+		err := forEach(files, jobs, func(file *Entry) error {
+			mu.Lock()
+			running++
+			most = max(most, running)
+			seen[file.entry.Name()]++
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Errorf("jobs=%d: unexpected error %v", jobs, err)
+		}
+		if most > max(1, jobs) {
+			t.Errorf("jobs=%d: %d calls ran at once", jobs, most)
+		}
+		if len(seen) != len(files) {
+			t.Errorf("jobs=%d: expected %d files to be visited, got %d", jobs, len(files), len(seen))
+		}
+		for name, n := range seen {
+			if n != 1 {
+				t.Errorf("jobs=%d: %s was visited %d times", jobs, name, n)
+			}
+		}
+	}
+
+	// every call still runs after one fails, and the error is returned
+	errFailed := errors.New("failed")
+	calls := 0
+	var mu sync.Mutex
+	err := forEach(files, 1, func(file *Entry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if file.entry.Name() == "file0.go" {
+			return errFailed
+		}
+		return nil
+	})
+	if !errors.Is(err, errFailed) {
+		t.Errorf("Expected the error from the first file, got %v", err)
+	}
+	if calls != len(files) {
+		t.Errorf("Expected %d calls, got %d", len(files), calls)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := testRepo(t)
+	if err := os.WriteFile(filepath.Join(dir, "src", "a.go"), []byte("package a\n\nfunc A() {}\n\nfunc C() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.DisplayName())
+	}
+	if expected := []string{".mailmap", "README", "docs", "src"}; !slices.Equal(names, expected) {
+		t.Fatalf("Expected entries %v, got %v", expected, names)
+	}
+
+	readme := entries[1]
+	if !readme.IsExecutable() || readme.Author() != "Robert" || readme.Message() != "Make README executable" {
+		t.Errorf("Unexpected README entry: %q %q, executable %v", readme.Author(), readme.Message(), readme.IsExecutable())
+	}
+	src := entries[3]
+	if !src.IsDir() || src.Diff() == nil || src.Diff().Plus != 2 {
+		t.Errorf("Unexpected src diff: %#v", src.Diff())
+	}
+
+	if _, err := List(filepath.Join(dir, "missing"), Options{}); err == nil {
+		t.Errorf("Expected an error listing a missing directory")
+	}
+}
+
+func TestListRepository(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.go", "package main\n")
+	write("logo.png", strings.Repeat("\x00", 150))
+	write("video.mp4", "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 2048\n")
+	write("src/lib.go", "package src\n")
+	write(".env", "SECRET=1\n")
+
+	repo := &fakeRepo{
+		root:    dir,
+		branch:  "main",
+		remotes: "origin\tgit@github.com:llimllib/git-ls.git (fetch)\n",
+		status:  " M main.go\n M logo.png\n M src/lib.go\n",
+		numStat: "3\t1\tmain.go\n-\t-\tlogo.png\n2\t0\tsrc/lib.go\n",
+		logs: map[string]string{
+			"main.go":   "abc1234\x002024-01-02\x00Alice\x00alice@example.com\x00Add main (#3)",
+			"logo.png":  "def5678\x002024-01-03\x00Bob\x00bob@example.com\x00Add a logo",
+			"video.mp4": "0123abc\x002024-01-04\x00Carol\x00carol@example.com\x00Add a video",
+		},
+		blames: map[string]string{
+			"main.go": "abc1234 1 1 1\nauthor Alice\n\tpackage main\n",
+		},
+		lfs:   map[string]bool{"video.mp4": true},
+		sizes: map[string]int64{"logo.png": 100},
+	}
+
+	entries, err := List(dir, Options{Blame: true, Repository: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]Entry)
+	for _, entry := range entries {
+		byName[entry.Name()] = entry
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries without the dotfile, got %d", len(entries))
+	}
+
+	main, logo, video, src := byName["main.go"], byName["logo.png"], byName["video.mp4"], byName["src"]
+	if main.Status() != " M" || main.Hash() != "abc1234" || main.Author() != "Alice" || main.Date() != "2024-01-02" {
+		t.Errorf("Unexpected main.go entry: %q %q %q %q", main.Status(), main.Hash(), main.Author(), main.Date())
+	}
+	if diff := main.Diff(); diff == nil || *diff != (Diff{Plus: 3, Minus: 1}) {
+		t.Errorf("Unexpected main.go diff: %#v", diff)
+	}
+	if blame := main.Blame(); blame == nil || *blame != (Blame{Author: "Alice", Percent: 100}) {
+		t.Errorf("Unexpected main.go blame: %#v", blame)
+	}
+	if diff := logo.Diff(); diff == nil || *diff != (Diff{Binary: 1, SizeDelta: 50}) {
+		t.Errorf("Unexpected logo.png diff: %#v", diff)
+	}
+	if lfs := video.LFS(); lfs == nil || *lfs != (LFS{Present: false, Size: 2048}) {
+		t.Errorf("Unexpected video.mp4 LFS: %#v", lfs)
+	}
+	if diff := src.Diff(); diff == nil || *diff != (Diff{Plus: 2}) {
+		t.Errorf("Unexpected src diff: %#v", diff)
+	}
+
+	if forge, err := FindForge(repo, dir, ""); err != nil || forge.CommitURL("abc1234") != "https://github.com/llimllib/git-ls/commit/abc1234" {
+		t.Errorf("Unexpected forge %#v: %v", forge, err)
+	}
+
+	repo.err = fmt.Errorf("not a git repository")
+	if _, err := List(dir, Options{Repository: repo}); err == nil {
+		t.Errorf("Expected the repository's error to be returned")
+	}
+}
+
+func TestListLong(t *testing.T) {
+	dir := t.TempDir()
+	for name, perm := range map[string]os.FileMode{"build.sh": 0o755, "main.go": 0o644, "run.sh": 0o644, "new.go": 0o755} {
+		// set the mode explicitly, since WriteFile's is subject to the umask
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x\n"), perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := &fakeRepo{
+		root: dir,
+		modes: map[string]uint32{
+			"build.sh": 0o100644,
+			"main.go":  0o100644,
+			"run.sh":   0o100755,
+			// files in subdirectories don't affect the listing
+			"sub/main.go": 0o100755,
+		},
+	}
+
+	entries, err := List(dir, Options{Long: true, Repository: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"build.sh": "-rwxr-xr-x +x",
+		"main.go":  "-rw-r--r--",
+		"run.sh":   "-rw-r--r-- -x",
+		"new.go":   "-rwxr-xr-x",
+	}
+	for _, entry := range entries {
+		stat := entry.Stat()
+		if stat == nil {
+			t.Fatalf("Expected %s to have a stat", entry.Name())
+		}
+		if label := modeLabel(stat); label != expected[entry.Name()] {
+			t.Errorf("Expected the mode of %s to be %q, got %q", entry.Name(), expected[entry.Name()], label)
+		}
+		if stat.Size != 2 || stat.Owner == "" || stat.Group == "" {
+			t.Errorf("Unexpected stat for %s: %#v", entry.Name(), stat)
+		}
+	}
+
+	entries, err = List(dir, Options{Repository: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := entries[0].Stat(); stat != nil {
+		t.Errorf("Expected no stat without Long, got %#v", stat)
+	}
+}
+
+func TestParseIndexModes(t *testing.T) {
+	out := "100644 e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 0\tmain.go\x00" +
+		"100755 e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 0\tbuild.sh\x00" +
+		"100644 e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 1\tconflict.go\x00" +
+		"100755 e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 2\tconflict.go\x00" +
+		"100644 e69de29bb2d1d6434b8b29ae775ad8c2e48c5391 0\tsub/lib.go\x00"
+	expected := map[string]uint32{"main.go": 0o100644, "build.sh": 0o100755, "conflict.go": 0o100644}
+	modes := parseIndexModes([]byte(out))
+	if len(modes) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, modes)
+	}
+	for path, mode := range expected {
+		if modes[path] != mode {
+			t.Errorf("Expected mode %o for %s, got %o", mode, path, modes[path])
+		}
+	}
+}
+
+func TestModeString(t *testing.T) {
+	testCases := []struct {
+		mode     os.FileMode
+		expected string
+	}{
+		{0o644, "-rw-r--r--"},
+		{0o755 | os.ModeDir, "drwxr-xr-x"},
+		{0o777 | os.ModeSymlink, "lrwxrwxrwx"},
+		{0o755 | os.ModeSetuid, "-rwsr-xr-x"},
+		{0o644 | os.ModeSetgid, "-rw-r-Sr--"},
+		{0o777 | os.ModeDir | os.ModeSticky, "drwxrwxrwt"},
+		{0o600 | os.ModeNamedPipe, "prw-------"},
+	}
+	for _, tc := range testCases {
+		if s := modeString(tc.mode); s != tc.expected {
+			t.Errorf("modeString(%v) = %q, expected %q", tc.mode, s, tc.expected)
+		}
+	}
+}
+
+func TestModTime(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		time     time.Time
+		expected string
+	}{
+		{time.Date(2024, 6, 3, 9, 5, 0, 0, time.UTC), "Jun  3 09:05"},
+		{time.Date(2024, 1, 20, 9, 5, 0, 0, time.UTC), "Jan 20 09:05"},
+		{time.Date(2023, 11, 20, 9, 5, 0, 0, time.UTC), "Nov 20  2023"},
+		{time.Date(2024, 7, 1, 9, 5, 0, 0, time.UTC), "Jul  1  2024"},
+	}
+	for _, tc := range testCases {
+		if s := modTime(tc.time, now); s != tc.expected {
+			t.Errorf("modTime(%v) = %q, expected %q", tc.time, s, tc.expected)
+		}
+	}
+}
+
+func TestLinkTemplate(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "/usr/local/bin/code --wait")
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"file", "file://{hostname}{path}"},
+		{"idea", "idea://open?file={path}"},
+		{"editor", "vscode://file{path}"},
+		{"myeditor://{path}", "myeditor://{path}"},
+	}
+	for _, tc := range testCases {
+		if template := LinkTemplate(tc.name); template != tc.expected {
+			t.Errorf("Expected %q for %s, got %q", tc.expected, tc.name, template)
+		}
+	}
+
+	t.Setenv("VISUAL", "vim")
+	if template := LinkTemplate("editor"); template != "file://{hostname}{path}" {
+		t.Errorf("Expected an unknown editor to use file links, got %q", template)
+	}
+
+	opts := RenderOptions{FileLink: LinkTemplate("zed"), DirLink: "git-ls://{path}"}
+	file := &Entry{entry: &mockDirEntry{name: "main.go"}, dir: "/src"}
+	dir := &Entry{entry: &mockDirEntry{name: "docs"}, dir: "/src", isDir: true}
+	if url := opts.fileLink(file, "host"); url != "zed://file/src/main.go" {
+		t.Errorf("Unexpected file link %q", url)
+	}
+	if url := opts.fileLink(dir, "host"); url != "git-ls:///src/docs" {
+		t.Errorf("Unexpected directory link %q", url)
+	}
+	opts = RenderOptions{}
+	if url := opts.fileLink(dir, "host"); url != "file://host/src/docs" {
+		t.Errorf("Unexpected default link %q", url)
+	}
+
+	// paths are escaped, so that they don't end the URL or its query early
+	odd := &Entry{entry: &mockDirEntry{name: "a#b c&d.go"}, dir: "/my src"}
+	expected := map[string]string{
+		"file":    "file://host/my%20src/a%23b%20c%26d.go",
+		"vscode":  "vscode://file/my%20src/a%23b%20c%26d.go",
+		"idea":    "idea://open?file=/my%20src/a%23b%20c%26d.go",
+		"sublime": "subl://open?url=file:///my%20src/a%23b%20c%26d.go",
+	}
+	for name, link := range expected {
+		opts = RenderOptions{FileLink: LinkTemplate(name)}
+		if url := opts.fileLink(odd, "host"); url != link {
+			t.Errorf("Expected the %s link %q, got %q", name, link, url)
+		}
+	}
+}
+
+func TestLoadRenderOptions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "my docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	repo := &fakeRepo{
+		root:    dir,
+		branch:  "feature/links",
+		head:    "0123456789abcdef0123456789abcdef01234567",
+		remotes: "origin\tgit@github.com:a/b.git (fetch)\n",
+		config: map[string]string{
+			"git-ls.filelink":               "forge",
+			"git-ls.reference.jira.pattern": `[A-Z]+-\d+`,
+			"git-ls.reference.jira.url":     "https://jira.example.com/browse/{0}",
+		},
+	}
+	file := &Entry{entry: &mockDirEntry{name: "README.md"}, dir: dir}
+	subdir := &Entry{entry: &mockDirEntry{name: "my docs"}, dir: dir, isDir: true}
+
+	opts, err := LoadRenderOptions(repo, dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.References) != 1 || opts.Forge == nil {
+		t.Errorf("Expected a forge and a reference, got %#v", opts)
+	}
+	if url := opts.fileLink(file, "host"); url != "https://github.com/a/b/blob/feature/links/README.md" {
+		t.Errorf("Unexpected file link %q", url)
+	}
+	if url := opts.fileLink(subdir, "host"); url != "https://github.com/a/b/tree/feature/links/my%20docs" {
+		t.Errorf("Unexpected directory link %q", url)
+	}
+
+	// the links given override the config, and a detached HEAD links to the
+	// commit
+	repo.branch = "HEAD"
+	if opts, err = LoadRenderOptions(repo, dir, "", "forge"); err != nil {
+		t.Fatal(err)
+	}
+	if url := opts.fileLink(subdir, "host"); url != "https://github.com/a/b/tree/0123456789abcdef0123456789abcdef01234567/my%20docs" {
+		t.Errorf("Unexpected directory link %q", url)
+	}
+
+	// without a forge, files link to their path
+	repo.remotes = ""
+	if opts, err = LoadRenderOptions(repo, dir, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if url := opts.fileLink(file, "host"); url != "file://host"+file.FullPath() {
+		t.Errorf("Unexpected file link %q", url)
+	}
+}
@@ -0,0 +1,912 @@
+package gitls
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// history finds the last commit to touch a path by walking the repository's
+// commits in-process, instead of running `git log -1` for every file. It
+// reads the commit-graph when there is one, which stores each commit's tree,
+// parents and date, and changed-path Bloom filters, which can tell us that a
+// commit didn't touch a path without reading any trees. Otherwise it reads
+// commits and trees from the loose objects and packs.
+//
+// It follows the same rules as git log: commits are visited newest first,
+// and a commit whose version of the path matches one of its parents' is
+// skipped, following only that parent. The first commit that differs from
+// all of its parents is the last one to touch the path.
+type history struct {
+	objects *objectStore
+	graph   *commitGraph
+	head    oid
+	shallow map[oid]bool
+	mailmap *mailmap
+	abbrev  int
+
+	mu      sync.Mutex
+	commits map[oid]*commitInfo
+}
+
+// commitInfo is the part of a commit needed to walk the history
+type commitInfo struct {
+	tree    oid
+	parents []oid
+	time    int64
+	// pos is the commit's position in the commit-graph, or -1 if it isn't
+	// in it
+	pos int
+}
+
+// openHistory opens the history of the repository containing dir. It returns
+// an error if the repository uses a feature that could make our answers
+// differ from git log's, in which case the caller should use git log.
+func openHistory(dir string) (*history, error) {
+	out, err := gitCommand(dir, "rev-parse", "--show-toplevel", "--git-common-dir", "HEAD").Output()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("unexpected rev-parse output %q", out)
+	}
+	root, commonDir := lines[0], lines[1]
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(dir, commonDir)
+	}
+	// SHA-256 repositories have longer ids, which we don't read
+	head, err := parseOID(lines[2])
+	if err != nil {
+		return nil, err
+	}
+
+	// grafts and replace refs change commits' parents, and a different
+	// object directory or mailmap is only known to git
+	if os.Getenv("GIT_OBJECT_DIRECTORY") != "" || os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES") != "" {
+		return nil, errors.New("object directory overridden by the environment")
+	}
+	objectsDir := filepath.Join(commonDir, "objects")
+	if _, err := os.Stat(filepath.Join(objectsDir, "info", "grafts")); err == nil {
+		return nil, errors.New("repository has grafts")
+	}
+	if hasReplaceRefs(commonDir) {
+		return nil, errors.New("repository has replace refs")
+	}
+	config, err := historyConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &history{
+		head:    head,
+		shallow: readShallow(filepath.Join(commonDir, "shallow")),
+		commits: make(map[oid]*commitInfo),
+	}
+	if contents, err := os.ReadFile(filepath.Join(root, ".mailmap")); err == nil {
+		h.mailmap = parseMailmap(string(contents))
+	}
+	if h.objects, err = openObjectStore(objectsDir); err != nil {
+		return nil, err
+	}
+	// git ignores the commit-graph in shallow repositories, since it records
+	// parents which the shallow file hides
+	if len(h.shallow) == 0 {
+		h.graph = openCommitGraph(objectsDir)
+	}
+	h.abbrev = abbrevLength(config["core.abbrev"], h.objects.count())
+	return h, nil
+}
+
+// close releases the files the history has open
+func (h *history) close() {
+	h.objects.close()
+	if h.graph != nil {
+		h.graph.close()
+	}
+}
+
+// historyConfig reads the configuration that affects git log's output, and
+// returns an error if any of it is something we don't support
+func historyConfig(dir string) (map[string]string, error) {
+	out, err := Git{}.Config(dir, `^(core\.abbrev|mailmap\.(file|blob)|extensions\.objectformat)$`)
+	if err != nil {
+		return nil, err
+	}
+	config := parseConfig(out)
+	if _, ok := config["mailmap.file"]; ok {
+		return nil, errors.New("mailmap.file is set")
+	}
+	if _, ok := config["mailmap.blob"]; ok {
+		return nil, errors.New("mailmap.blob is set")
+	}
+	if format, ok := config["extensions.objectformat"]; ok && format != "sha1" {
+		return nil, fmt.Errorf("unsupported object format %s", format)
+	}
+	return config, nil
+}
+
+// hasReplaceRefs returns true if the repository has any refs under
+// refs/replace, which git uses in place of the objects they name
+func hasReplaceRefs(commonDir string) bool {
+	if os.Getenv("GIT_NO_REPLACE_OBJECTS") != "" {
+		return false
+	}
+	if entries, err := os.ReadDir(filepath.Join(commonDir, "refs", "replace")); err == nil && len(entries) > 0 {
+		return true
+	}
+	packed, _ := os.ReadFile(filepath.Join(commonDir, "packed-refs"))
+	return bytes.Contains(packed, []byte(" refs/replace/"))
+}
+
+// readShallow reads the ids of the commits whose parents are missing from a
+// shallow clone
+func readShallow(path string) map[oid]bool {
+	shallow := make(map[oid]bool)
+	contents, _ := os.ReadFile(path)
+	for _, line := range strings.Fields(string(contents)) {
+		if id, err := parseOID(line); err == nil {
+			shallow[id] = true
+		}
+	}
+	return shallow
+}
+
+// abbrevLength returns the minimum length of abbreviated hashes. Like git, by
+// default it scales with the size of the repository so that abbreviations
+// are unlikely to be ambiguous, and is at least 7.
+func abbrevLength(config string, objects int) int {
+	switch config {
+	case "", "auto":
+	case "no", "false", "off":
+		return 40
+	default:
+		if n, err := strconv.Atoi(config); err == nil {
+			return max(4, min(n, 40))
+		}
+	}
+	// git uses half as many hex digits as it takes bits to count the
+	// objects, rounded up
+	return max(7, (bits.Len(uint(objects))+1)/2)
+}
+
+// abbreviate returns the shortest unique abbreviation of id that's at least
+// the minimum length
+func (h *history) abbreviate(id oid) string {
+	full := id.String()
+	n := h.abbrev
+	for n < len(full) && h.objects.ambiguous(full[:n]) {
+		n++
+	}
+	return full[:n]
+}
+
+// commit returns the information about a commit needed to walk the history,
+// from the commit-graph if possible
+func (h *history) commit(id oid) (*commitInfo, error) {
+	h.mu.Lock()
+	info, ok := h.commits[id]
+	h.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	if pos, ok := h.graph.find(id); ok {
+		var err error
+		if info, err = h.graph.commit(pos); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := h.objects.readTyped(id, objCommit)
+		if err != nil {
+			return nil, err
+		}
+		c, err := parseCommit(data)
+		if err != nil {
+			return nil, err
+		}
+		info = &commitInfo{tree: c.tree, parents: c.parents, time: c.committerTime, pos: -1}
+	}
+	if h.shallow[id] {
+		info.parents = nil
+	}
+
+	h.mu.Lock()
+	h.commits[id] = info
+	h.mu.Unlock()
+	return info, nil
+}
+
+// pathEntry is the version of a path in a tree. Two commits have the same
+// version of a path if its entries are equal, including when it's missing
+// from both.
+type pathEntry struct {
+	mode uint32
+	id   oid
+}
+
+// lookup finds path, which is relative to the root of the repository, in a
+// tree. An empty path is the tree itself.
+func (h *history) lookup(tree oid, path string) (pathEntry, error) {
+	entry := pathEntry{mode: 040000, id: tree}
+	if path == "" {
+		return entry, nil
+	}
+	for _, name := range strings.Split(path, "/") {
+		if entry.mode != 040000 {
+			return pathEntry{}, nil
+		}
+		data, err := h.objects.readTyped(entry.id, objTree)
+		if err != nil {
+			return pathEntry{}, err
+		}
+		entries, err := parseTree(data)
+		if err != nil {
+			return pathEntry{}, err
+		}
+		found := false
+		for _, e := range entries {
+			if e.name == name {
+				entry = pathEntry{mode: e.mode, id: e.id}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return pathEntry{}, nil
+		}
+	}
+	return entry, nil
+}
+
+// lastCommit returns the id of the last commit to touch path, which is
+// relative to the root of the repository, and false if no commit touched it.
+// It gives up with ctx's error if ctx is done first.
+func (h *history) lastCommit(ctx context.Context, path string) (oid, bool, error) {
+	var queue commitQueue
+	seen := make(map[oid]bool)
+	push := func(id oid) error {
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		info, err := h.commit(id)
+		if err != nil {
+			return err
+		}
+		heap.Push(&queue, queuedCommit{id: id, info: info, seq: len(seen)})
+		return nil
+	}
+
+	// each commit's version of the path is needed when it's visited and
+	// again when its children are
+	versions := make(map[oid]pathEntry)
+	version := func(id oid, info *commitInfo) (pathEntry, error) {
+		if entry, ok := versions[id]; ok {
+			return entry, nil
+		}
+		entry, err := h.lookup(info.tree, path)
+		versions[id] = entry
+		return entry, err
+	}
+
+	if err := push(h.head); err != nil {
+		return oid{}, false, err
+	}
+	for queue.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return oid{}, false, err
+		}
+		c := heap.Pop(&queue).(queuedCommit)
+		entry, err := version(c.id, c.info)
+		if err != nil {
+			return oid{}, false, err
+		}
+
+		// a root commit touched the path if it added it
+		if len(c.info.parents) == 0 {
+			if entry != (pathEntry{}) {
+				return c.id, true, nil
+			}
+			continue
+		}
+
+		same := -1
+		for i, parent := range c.info.parents {
+			// the Bloom filters record the paths changed relative to the
+			// first parent
+			if i == 0 && h.graph.unchanged(c.info.pos, path) {
+				same = i
+				break
+			}
+			info, err := h.commit(parent)
+			if err != nil {
+				return oid{}, false, err
+			}
+			parentEntry, err := version(parent, info)
+			if err != nil {
+				return oid{}, false, err
+			}
+			if parentEntry == entry {
+				same = i
+				break
+			}
+		}
+		if same < 0 {
+			return c.id, true, nil
+		}
+		if err := push(c.info.parents[same]); err != nil {
+			return oid{}, false, err
+		}
+	}
+	return oid{}, false, nil
+}
+
+// log returns the last commit to touch path in the same format as gitLog, or
+// nil if there isn't one
+func (h *history) log(ctx context.Context, path string) ([]byte, error) {
+	id, ok, err := h.lastCommit(ctx, path)
+	if err != nil || !ok {
+		return nil, err
+	}
+	data, err := h.objects.readTyped(id, objCommit)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCommit(data)
+	if err != nil {
+		return nil, err
+	}
+
+	name, email := h.mailmap.lookup(c.authorName, c.authorEmail)
+	fields := []string{h.abbreviate(id), c.authorDate.Format("2006-01-02"), name, email, c.subject()}
+	return []byte(strings.Join(fields, "\x00")), nil
+}
+
+// wrap returns a gitLog query that uses the history, and falls back to
+// gitLog for paths outside the repository or if reading the history fails.
+// curdir is the path of the files' directory relative to the root of the
+// repository.
+func (h *history) wrap(curdir string, gitLog query) query {
+	return func(ctx context.Context, file *Entry) ([]byte, error) {
+		path := filepath.ToSlash(filepath.Join(curdir, file.entry.Name()))
+		if path == ".." || strings.HasPrefix(path, "../") {
+			return gitLog(ctx, file)
+		}
+		if path == "." {
+			path = ""
+		}
+		out, err := h.log(ctx, path)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return gitLog(ctx, file)
+		}
+		return out, nil
+	}
+}
+
+type queuedCommit struct {
+	id   oid
+	info *commitInfo
+	seq  int
+}
+
+// commitQueue orders commits newest first, and in the order they were
+// queued when their dates are equal, as git does
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].info.time != q[j].info.time {
+		return q[i].info.time > q[j].info.time
+	}
+	return q[i].seq < q[j].seq
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// commit is a parsed commit object
+type commit struct {
+	tree          oid
+	parents       []oid
+	authorName    string
+	authorEmail   string
+	authorDate    time.Time
+	committerTime int64
+	message       string
+}
+
+// parseCommit parses a commit object, which is a list of headers followed by
+// a blank line and the message
+func parseCommit(data []byte) (*commit, error) {
+	c := &commit{}
+	header, message, _ := strings.Cut(string(data), "\n\n")
+	c.message = message
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "tree":
+			c.tree, err = parseOID(value)
+		case "parent":
+			var parent oid
+			parent, err = parseOID(value)
+			c.parents = append(c.parents, parent)
+		case "author":
+			c.authorName, c.authorEmail, c.authorDate, err = parseIdent(value)
+		case "committer":
+			var date time.Time
+			_, _, date, err = parseIdent(value)
+			c.committerTime = date.Unix()
+		case "encoding":
+			// git log converts messages to UTF-8, which we can't
+			if !strings.EqualFold(value, "utf-8") && !strings.EqualFold(value, "utf8") {
+				err = fmt.Errorf("unsupported encoding %s", value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// parseIdent parses an author or committer, which looks like
+// "Name <email> 1700000000 +0100". The date is in the timezone it was
+// recorded in, which is the one git log shows.
+func parseIdent(ident string) (string, string, time.Time, error) {
+	open := strings.IndexByte(ident, '<')
+	close := strings.LastIndexByte(ident, '>')
+	if open < 0 || close < open {
+		return "", "", time.Time{}, fmt.Errorf("invalid ident %q", ident)
+	}
+	name := strings.TrimSpace(ident[:open])
+	email := ident[open+1 : close]
+
+	fields := strings.Fields(ident[close+1:])
+	if len(fields) != 2 {
+		return "", "", time.Time{}, fmt.Errorf("invalid ident %q", ident)
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	tz, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	offset := (tz/100*60 + tz%100) * 60
+	return name, email, time.Unix(seconds, 0).In(time.FixedZone("", offset)), nil
+}
+
+// subject returns the commit's subject as git log's %s does: the first
+// paragraph of the message, with its lines joined by spaces
+func (c *commit) subject() string {
+	var lines []string
+	started := false
+	for _, line := range strings.Split(c.message, "\n") {
+		line = strings.TrimRight(line, " \t\r\n\v\f")
+		if line == "" {
+			if started {
+				break
+			}
+			continue
+		}
+		started = true
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, " ")
+}
+
+// mailmap maps the names and emails in commits to canonical ones, as
+// described in gitmailmap(5). Emails and names are matched case-insensitively.
+type mailmap struct {
+	// entries maps a lowercased email to its replacements
+	entries map[string]*mailmapEntry
+}
+
+type mailmapEntry struct {
+	name, email string
+	// names holds replacements that only apply to a particular lowercased
+	// name with this email
+	names map[string][2]string
+}
+
+// parseMailmap parses a .mailmap file, where each line is one of
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func parseMailmap(contents string) *mailmap {
+	m := &mailmap{entries: make(map[string]*mailmapEntry)}
+	for _, line := range strings.Split(contents, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		name1, email1, rest, ok := parseMailmapIdent(line)
+		if !ok {
+			continue
+		}
+		name2, email2, _, ok := parseMailmapIdent(rest)
+		if !ok {
+			// only the name is replaced
+			email2, email1 = email1, ""
+		}
+
+		key := strings.ToLower(email2)
+		entry := m.entries[key]
+		if entry == nil {
+			entry = &mailmapEntry{names: make(map[string][2]string)}
+			m.entries[key] = entry
+		}
+		if name2 != "" {
+			entry.names[strings.ToLower(name2)] = [2]string{name1, email1}
+			continue
+		}
+		if name1 != "" {
+			entry.name = name1
+		}
+		if email1 != "" {
+			entry.email = email1
+		}
+	}
+	return m
+}
+
+// parseMailmapIdent parses "Name <email>" from the start of s and returns
+// the rest of it
+func parseMailmapIdent(s string) (string, string, string, bool) {
+	open := strings.IndexByte(s, '<')
+	if open < 0 {
+		return "", "", "", false
+	}
+	close := strings.IndexByte(s[open:], '>')
+	if close < 0 {
+		return "", "", "", false
+	}
+	close += open
+	return strings.TrimSpace(s[:open]), s[open+1 : close], s[close+1:], true
+}
+
+// lookup returns the canonical name and email for an author
+func (m *mailmap) lookup(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	entry := m.entries[strings.ToLower(email)]
+	if entry == nil {
+		return name, email
+	}
+	replacement, ok := entry.names[strings.ToLower(name)]
+	if !ok {
+		replacement = [2]string{entry.name, entry.email}
+	}
+	if replacement[0] != "" {
+		name = replacement[0]
+	}
+	if replacement[1] != "" {
+		email = replacement[1]
+	}
+	return name, email
+}
+
+// commitGraph is a repository's commit-graph, which may be split into a
+// chain of files. See gitformat-commit-graph(5).
+type commitGraph struct {
+	layers []*graphLayer
+}
+
+// graphLayer is one commit-graph file. Commit positions count through all
+// of the layers in a chain, starting from the base.
+type graphLayer struct {
+	data []byte
+	// base is the position of the layer's first commit
+	base int
+	n    int
+
+	fanout, oids, commits, edges []byte
+
+	// bloomIndex holds the end offset of each commit's Bloom filter in
+	// bloomData
+	bloomIndex, bloomData []byte
+	bloomHashes           int
+	bloomVersion          int
+}
+
+// openCommitGraph opens the commit-graph in objectsDir, which is either a
+// single file or a chain of them, and returns nil if there isn't one or it
+// can't be read
+func openCommitGraph(objectsDir string) *commitGraph {
+	info := filepath.Join(objectsDir, "info")
+	paths := []string{filepath.Join(info, "commit-graph")}
+	if _, err := os.Stat(paths[0]); err != nil {
+		chain, err := os.ReadFile(filepath.Join(info, "commit-graphs", "commit-graph-chain"))
+		if err != nil {
+			return nil
+		}
+		paths = nil
+		for _, hash := range strings.Fields(string(chain)) {
+			paths = append(paths, filepath.Join(info, "commit-graphs", "graph-"+hash+".graph"))
+		}
+	}
+
+	g := &commitGraph{}
+	base := 0
+	for _, path := range paths {
+		layer, err := openGraphLayer(path, base)
+		if err != nil {
+			g.close()
+			return nil
+		}
+		g.layers = append(g.layers, layer)
+		base += layer.n
+	}
+	return g
+}
+
+func openGraphLayer(path string, base int) (*graphLayer, error) {
+	data, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	layer := &graphLayer{data: data, base: base}
+	if err := layer.parse(); err != nil {
+		_ = syscall.Munmap(data)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return layer, nil
+}
+
+// parse finds the chunks in the file. The header is the signature "CGPH",
+// the version, the hash version, the number of chunks and the number of base
+// layers, followed by a table of chunk ids and offsets.
+func (l *graphLayer) parse() error {
+	data := l.data
+	if len(data) < 8 || string(data[:4]) != "CGPH" || data[4] != 1 || data[5] != 1 {
+		return errors.New("unsupported commit-graph version")
+	}
+	chunks := make(map[string][]byte)
+	numChunks := int(data[6])
+	if len(data) < 8+(numChunks+1)*12 {
+		return errors.New("truncated commit-graph")
+	}
+	for i := 0; i < numChunks; i++ {
+		entry := data[8+i*12:]
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[16:])
+		if start > end || end > uint64(len(data)) {
+			return errors.New("invalid commit-graph chunk")
+		}
+		chunks[string(entry[:4])] = data[start:end]
+	}
+
+	l.fanout, l.oids, l.commits, l.edges = chunks["OIDF"], chunks["OIDL"], chunks["CDAT"], chunks["EDGE"]
+	if len(l.fanout) != 256*4 {
+		return errors.New("missing commit-graph fanout")
+	}
+	l.n = int(binary.BigEndian.Uint32(l.fanout[255*4:]))
+	if len(l.oids) != l.n*20 || len(l.commits) != l.n*36 || len(l.edges)%4 != 0 {
+		return errors.New("invalid commit-graph")
+	}
+
+	// the Bloom filters are optional, and we only read them if they use
+	// the hash git uses now
+	index, filters := chunks["BIDX"], chunks["BDAT"]
+	if len(index) == l.n*4 && len(filters) >= 12 {
+		l.bloomVersion = int(binary.BigEndian.Uint32(filters))
+		l.bloomHashes = int(binary.BigEndian.Uint32(filters[4:]))
+		l.bloomIndex = index
+		l.bloomData = filters[12:]
+	}
+	return nil
+}
+
+func (g *commitGraph) close() {
+	for _, layer := range g.layers {
+		_ = syscall.Munmap(layer.data)
+	}
+}
+
+// find returns the position of a commit in the graph
+func (g *commitGraph) find(id oid) (int, bool) {
+	if g == nil {
+		return 0, false
+	}
+	for _, l := range g.layers {
+		lo, hi := fanoutRange(l.fanout, id[0])
+		for lo < hi {
+			mid := (lo + hi) / 2
+			switch cmp := bytes.Compare(l.oids[mid*20:mid*20+20], id[:]); {
+			case cmp == 0:
+				return l.base + mid, true
+			case cmp < 0:
+				lo = mid + 1
+			default:
+				hi = mid
+			}
+		}
+	}
+	return 0, false
+}
+
+// layer returns the layer containing the commit at pos, and its index within
+// the layer. A corrupt commit-graph can refer to positions that don't exist.
+func (g *commitGraph) layer(pos int) (*graphLayer, int, error) {
+	for _, l := range g.layers {
+		if pos >= 0 && pos < l.base+l.n {
+			return l, pos - l.base, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("commit-graph position %d out of range", pos)
+}
+
+func (g *commitGraph) oid(pos int) (oid, error) {
+	var id oid
+	l, i, err := g.layer(pos)
+	if err != nil {
+		return id, err
+	}
+	copy(id[:], l.oids[i*20:])
+	return id, nil
+}
+
+// commit reads a commit's data. Each commit has its tree, the positions of
+// its first two parents, and its generation and date. If it has more than two
+// parents, the second parent is instead the position in the edge list of the
+// rest of them, the last of which has its top bit set. It returns an error if
+// the commit-graph is corrupt, so that the history is read some other way.
+func (g *commitGraph) commit(pos int) (*commitInfo, error) {
+	const noParent = 0x70000000
+	l, i, err := g.layer(pos)
+	if err != nil {
+		return nil, err
+	}
+	data := l.commits[i*36 : i*36+36]
+	info := &commitInfo{pos: pos}
+	copy(info.tree[:], data)
+
+	var parents []uint32
+	parent1 := binary.BigEndian.Uint32(data[20:])
+	parent2 := binary.BigEndian.Uint32(data[24:])
+	if parent1 != noParent {
+		parents = append(parents, parent1)
+	}
+	switch {
+	case parent2 == noParent:
+	case parent2&0x80000000 == 0:
+		parents = append(parents, parent2)
+	default:
+		for e := int(parent2&0x7fffffff) * 4; ; e += 4 {
+			if e+4 > len(l.edges) {
+				return nil, errors.New("commit-graph edge list out of range")
+			}
+			edge := binary.BigEndian.Uint32(l.edges[e:])
+			parents = append(parents, edge&0x7fffffff)
+			if edge&0x80000000 != 0 {
+				break
+			}
+		}
+	}
+	for _, parent := range parents {
+		id, err := g.oid(int(parent))
+		if err != nil {
+			return nil, err
+		}
+		info.parents = append(info.parents, id)
+	}
+
+	// the date is the low 34 bits of the last 8 bytes
+	info.time = int64(binary.BigEndian.Uint64(data[28:]) & (1<<34 - 1))
+	return info, nil
+}
+
+// unchanged returns true if the commit's Bloom filter shows that it didn't
+// change path relative to its first parent. Bloom filters can have false
+// positives but not false negatives, so false means it might have.
+func (g *commitGraph) unchanged(pos int, path string) bool {
+	if g == nil || pos < 0 || path == "" {
+		return false
+	}
+	l, i, err := g.layer(pos)
+	if err != nil || l.bloomIndex == nil {
+		return false
+	}
+	// version 1 filters hashed bytes above 0x7f incorrectly
+	if l.bloomVersion != 2 && !isASCII(path) {
+		return false
+	}
+	if l.bloomVersion != 1 && l.bloomVersion != 2 {
+		return false
+	}
+
+	start := uint32(0)
+	if i > 0 {
+		start = binary.BigEndian.Uint32(l.bloomIndex[(i-1)*4:])
+	}
+	end := binary.BigEndian.Uint32(l.bloomIndex[i*4:])
+	if start >= end || int(end) > len(l.bloomData) {
+		// an empty filter tells us nothing
+		return false
+	}
+	filter := l.bloomData[start:end]
+
+	// a path's hashes are derived from two murmur3 hashes of it
+	h0 := murmur3([]byte(path), 0x293ae76f)
+	h1 := murmur3([]byte(path), 0x7e646e2c)
+	bitCount := uint64(len(filter)) * 8
+	for n := 0; n < l.bloomHashes; n++ {
+		bit := uint64(h0+uint32(n)*h1) % bitCount
+		if filter[bit/8]&(1<<(bit%8)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// murmur3 is the 32-bit murmur3 hash, which git uses for Bloom filters
+func murmur3(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	n := len(data)
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+		data = data[4:]
+	}
+
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,256 @@
+package gitls
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testRepo creates a repository with a history that exercises the history
+// walker: merges with and without changes of their own, a deleted file,
+// a mode change, multi-line messages, several time zones and a .mailmap
+func testRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_COMMITTER_NAME", "Committer")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := gitCommand(dir, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, contents string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	date := 1700000000
+	commit := func(author, tz, message string) {
+		t.Helper()
+		date += 60
+		t.Setenv("GIT_AUTHOR_NAME", author)
+		t.Setenv("GIT_AUTHOR_EMAIL", strings.ToLower(author)+"@example.com")
+		t.Setenv("GIT_AUTHOR_DATE", strconv.Itoa(date)+" "+tz)
+		t.Setenv("GIT_COMMITTER_DATE", strconv.Itoa(date)+" +0000")
+		git("add", "-A")
+		git("commit", "-q", "--allow-empty", "--cleanup=verbatim", "-m", message)
+	}
+
+	git("init", "-q", "-b", "main")
+	write("README", "hello\n")
+	write("src/a.go", "package a\n")
+	write("src/b.go", "package b\n")
+	commit("Alice", "+0000", "Initial commit")
+
+	git("checkout", "-q", "-b", "feature")
+	write("src/a.go", "package a\n\nfunc A() {}\n")
+	commit("Bob", "-0700", "Add A\nacross two lines\n\nwith a body")
+	write("docs/guide.md", "# Guide\n")
+	commit("Bob", "+1300", "Add a guide")
+
+	git("checkout", "-q", "main")
+	write("src/b.go", "package b\n\nfunc B() {}\n")
+	commit("Carol", "+0530", "  Add B  ")
+	write("old.txt", "old\n")
+	commit("Alice", "-1100", "Add old.txt")
+
+	date += 60
+	t.Setenv("GIT_COMMITTER_DATE", strconv.Itoa(date)+" +0000")
+	git("merge", "-q", "--no-ff", "--no-edit", "feature")
+	git("rm", "-q", "old.txt")
+	commit("Carol", "+0000", "Remove old.txt (#12)")
+	if err := os.Chmod(filepath.Join(dir, "README"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commit("Bob", "+0200", "Make README executable")
+	commit("Alice", "+0000", "An empty commit")
+	write(".mailmap", "Robert <robert@example.com> <bob@example.com>\n")
+	return dir
+}
+
+func TestHistoryMatchesGitLog(t *testing.T) {
+	dir := testRepo(t)
+	paths := []string{"", "README", "src", "src/a.go", "src/b.go", "docs", "docs/guide.md", "old.txt", "missing"}
+
+	check := func(layout string) {
+		h, err := openHistory(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", layout, err)
+		}
+		defer h.close()
+		for _, path := range paths {
+			name := filepath.Base(path)
+			if path == "" {
+				name = "."
+			}
+			file := &Entry{entry: dotEntry{name: name}, dir: filepath.Join(dir, filepath.Dir(path))}
+			expected, _ := Git{}.Log(context.Background(), file)
+			actual, err := h.log(context.Background(), path)
+			if err != nil {
+				t.Errorf("%s: %q: %v", layout, path, err)
+			} else if string(actual) != string(expected) {
+				t.Errorf("%s: %q: expected %q, got %q", layout, path, expected, actual)
+			}
+		}
+	}
+
+	check("loose objects")
+	if out, err := gitCommand(dir, "gc", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git gc failed: %v\n%s", err, out)
+	}
+	check("packed objects")
+	if out, err := gitCommand(dir, "commit-graph", "write", "--reachable", "--changed-paths").CombinedOutput(); err != nil {
+		t.Fatalf("git commit-graph failed: %v\n%s", err, out)
+	}
+	check("commit-graph")
+}
+
+func TestCorruptCommitGraph(t *testing.T) {
+	dir := testRepo(t)
+	if out, err := gitCommand(dir, "commit-graph", "write", "--reachable").CombinedOutput(); err != nil {
+		t.Fatalf("git commit-graph failed: %v\n%s", err, out)
+	}
+
+	// point every commit's first parent past the end of the graph
+	path := filepath.Join(dir, ".git", "objects", "info", "commit-graph")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := &graphLayer{data: data}
+	if err := layer.parse(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < layer.n; i++ {
+		binary.BigEndian.PutUint32(layer.commits[i*36+20:], uint32(layer.n+100))
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := openHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	if _, err := h.log(context.Background(), "README"); err == nil {
+		t.Errorf("Expected an error from the corrupt commit-graph")
+	}
+
+	// the listing falls back to git log, which would fail on the corrupt
+	// commit-graph too unless it's told not to read it
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "core.commitGraph")
+	t.Setenv("GIT_CONFIG_VALUE_0", "false")
+	file := &Entry{entry: dotEntry{name: "README"}, dir: dir}
+	expected, _ := Git{}.Log(context.Background(), file)
+	actual, err := h.wrap("", Git{}.Log)(context.Background(), file)
+	if err != nil || len(expected) == 0 || string(actual) != string(expected) {
+		t.Errorf("Expected %q, got %q, %v", expected, actual, err)
+	}
+}
+
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		input    string
+		seed     uint32
+		expected uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+		{"Hello, world!", 1234, 0xfaf6cdb3},
+		{"abc", 0, 0xb3dd93fa},
+	}
+	for _, tc := range tests {
+		if actual := murmur3([]byte(tc.input), tc.seed); actual != tc.expected {
+			t.Errorf("murmur3(%q, %d): expected %#x, got %#x", tc.input, tc.seed, tc.expected, actual)
+		}
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello, world")
+	// the sizes of the base and result, then copy 5 bytes from offset 0,
+	// insert " there" and copy 3 bytes from offset 5
+	delta := []byte{12, 14, 0x90, 5, 6, ' ', 't', 'h', 'e', 'r', 'e', 0x91, 5, 3}
+	actual, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != "hello there, w" {
+		t.Errorf("Expected %q, got %q", "hello there, w", actual)
+	}
+
+	// a copy past the end of the base is invalid
+	if _, err := applyDelta(base, []byte{12, 20, 0x91, 5, 20}); err == nil {
+		t.Error("Expected an error for an out of range copy")
+	}
+}
+
+func TestMailmap(t *testing.T) {
+	m := parseMailmap(`# comment
+Proper Name <commit@example.com>
+<proper@example.com> <Other@Example.com>
+Both <both@example.com> <old@example.com>
+Only Bob <bob@example.com> Bob <shared@example.com>
+Only Ann <ann@example.com> ann <shared@example.com>
+`)
+	tests := []struct {
+		name, email   string
+		expectedName  string
+		expectedEmail string
+	}{
+		{"Whoever", "commit@example.com", "Proper Name", "commit@example.com"},
+		{"Other", "other@example.com", "Other", "proper@example.com"},
+		{"Old", "old@example.com", "Both", "both@example.com"},
+		{"bob", "shared@example.com", "Only Bob", "bob@example.com"},
+		{"Ann", "shared@example.com", "Only Ann", "ann@example.com"},
+		{"Someone", "shared@example.com", "Someone", "shared@example.com"},
+		{"Unknown", "unknown@example.com", "Unknown", "unknown@example.com"},
+	}
+	for _, tc := range tests {
+		name, email := m.lookup(tc.name, tc.email)
+		if name != tc.expectedName || email != tc.expectedEmail {
+			t.Errorf("%s <%s>: expected %s <%s>, got %s <%s>", tc.name, tc.email, tc.expectedName, tc.expectedEmail, name, email)
+		}
+	}
+}
+
+func TestCommitSubject(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected string
+	}{
+		{"Subject\n", "Subject"},
+		{"Subject\n\nBody\n", "Subject"},
+		{"Wrapped  \nsubject\n\nBody", "Wrapped subject"},
+		{"\n\nLeading blank lines\n", "Leading blank lines"},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		c := &commit{message: tc.message}
+		if actual := c.subject(); actual != tc.expected {
+			t.Errorf("subject of %q: expected %q, got %q", tc.message, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package gitls
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the Nerd Font icons for kinds of entries which aren't picked by their name
+const (
+	iconFile       = "\uf15b" // nf-fa-file
+	iconDir        = "\ue5ff" // nf-custom-folder
+	iconExecutable = "\uf489" // nf-oct-terminal
+	iconSymlink    = "\uf481" // nf-oct-file_symlink_file
+	iconSymlinkDir = "\uf482" // nf-oct-file_symlink_directory
+	iconSubmodule  = "\ue5fb" // nf-custom-folder_git
+)
+
+// iconWidth is the width of an icon and the space after it
+const iconWidth = 2
+
+// dirIcons are the icons for well-known directories, by lowercase name
+var dirIcons = map[string]string{
+	".git":         "\ue702",
+	".github":      "\ue5fd",
+	".vscode":      "\ue70c",
+	"node_modules": "\ue5fa",
+}
+
+// fileIcons are the icons for well-known files, by lowercase name
+var fileIcons = map[string]string{
+	".editorconfig":  "\ue652",
+	".env":           "\uf462",
+	".gitattributes": "\uf1d3",
+	".gitignore":     "\uf1d3",
+	".gitmodules":    "\uf1d3",
+	"cargo.lock":     "\ue7a8",
+	"cargo.toml":     "\ue7a8",
+	"copying":        "\ue60a",
+	"dockerfile":     "\uf308",
+	"gemfile":        "\ue791",
+	"gnumakefile":    "\ue779",
+	"go.mod":         "\ue627",
+	"go.sum":         "\ue627",
+	"license":        "\ue60a",
+	"makefile":       "\ue779",
+	"package.json":   "\ue71e",
+}
+
+// extensionIcons are the icons for files, by lowercase extension
+var extensionIcons = map[string]string{
+	"7z":       "\uf410",
+	"bash":     "\uf489",
+	"bmp":      "\uf1c5",
+	"bz2":      "\uf410",
+	"c":        "\ue61e",
+	"cc":       "\ue61d",
+	"cpp":      "\ue61d",
+	"css":      "\ue749",
+	"csv":      "\uf1c3",
+	"db":       "\uf1c0",
+	"diff":     "\uf440",
+	"ex":       "\ue62d",
+	"exs":      "\ue62d",
+	"fish":     "\uf489",
+	"flac":     "\uf001",
+	"gif":      "\uf1c5",
+	"go":       "\ue627",
+	"gz":       "\uf410",
+	"h":        "\uf0fd",
+	"hpp":      "\uf0fd",
+	"hs":       "\ue777",
+	"htm":      "\uf13b",
+	"html":     "\uf13b",
+	"ico":      "\uf1c5",
+	"ini":      "\ue615",
+	"java":     "\ue738",
+	"jpeg":     "\uf1c5",
+	"jpg":      "\uf1c5",
+	"js":       "\ue74e",
+	"json":     "\ue60b",
+	"jsx":      "\ue7ba",
+	"kt":       "\ue634",
+	"lock":     "\uf023",
+	"lua":      "\ue620",
+	"markdown": "\uf48a",
+	"md":       "\uf48a",
+	"mkv":      "\uf03d",
+	"mov":      "\uf03d",
+	"mp3":      "\uf001",
+	"mp4":      "\uf03d",
+	"ogg":      "\uf001",
+	"patch":    "\uf440",
+	"pdf":      "\uf1c1",
+	"php":      "\ue608",
+	"png":      "\uf1c5",
+	"py":       "\ue606",
+	"rb":       "\ue791",
+	"rs":       "\ue7a8",
+	"scss":     "\ue603",
+	"sh":       "\uf489",
+	"sql":      "\uf1c0",
+	"sqlite":   "\uf1c0",
+	"svg":      "\uf1c5",
+	"swift":    "\ue755",
+	"tar":      "\uf410",
+	"tgz":      "\uf410",
+	"toml":     "\ue615",
+	"ts":       "\ue628",
+	"tsx":      "\ue7ba",
+	"txt":      "\uf15c",
+	"vim":      "\ue62b",
+	"wav":      "\uf001",
+	"webm":     "\uf03d",
+	"webp":     "\uf1c5",
+	"xz":       "\uf410",
+	"yaml":     "\ue615",
+	"yml":      "\ue615",
+	"zip":      "\uf410",
+	"zsh":      "\uf489",
+}
+
+// icon returns the Nerd Font icon for file. Symlinks and submodules, or other
+// repositories within this one, have their own icons, and the rest are picked
+// by their name, then their extension, falling back to an icon for files,
+// executables or directories.
+func icon(file *Entry) string {
+	name := strings.ToLower(file.entry.Name())
+	if file.entry.Type()&fs.ModeSymlink != 0 {
+		if stat, err := os.Stat(file.FullPath()); err == nil && stat.IsDir() {
+			return iconSymlinkDir
+		}
+		return iconSymlink
+	}
+	if file.isDir {
+		if icon, ok := dirIcons[name]; ok {
+			return icon
+		}
+		if name != "." && name != ".." {
+			if _, err := os.Lstat(filepath.Join(file.FullPath(), ".git")); err == nil {
+				return iconSubmodule
+			}
+		}
+		return iconDir
+	}
+	if icon, ok := fileIcons[name]; ok {
+		return icon
+	}
+	if icon, ok := extensionIcons[strings.TrimPrefix(filepath.Ext(name), ".")]; ok {
+		return icon
+	}
+	if file.isExe {
+		return iconExecutable
+	}
+	return iconFile
+}
@@ -0,0 +1,96 @@
+package gitls
+
+import (
+	"slices"
+	"strings"
+)
+
+// column is a column of the listing, as the layout sees it
+type column struct {
+	// width is the width of the column's widest cell, and min is the
+	// narrowest it can be shrunk to by truncating its cells
+	width, min int
+	// priority decides which columns give up their space first when a line
+	// doesn't fit: lower priorities shrink first, and optional columns are
+	// dropped in the same order if shrinking isn't enough
+	priority int
+	optional bool
+}
+
+// layout returns the width to give each column so that the columns, with a
+// space between each pair, fit in maxWidth cells. Columns which are empty or
+// dropped get a width of 0. The columns which aren't optional are never
+// dropped, so they may still not fit if maxWidth is small enough.
+func layout(columns []column, maxWidth int) []int {
+	widths := make([]int, len(columns))
+	for i, col := range columns {
+		widths[i] = col.width
+	}
+	total := func(widths []int) int {
+		n := -1
+		for _, w := range widths {
+			if w > 0 {
+				n += w + 1
+			}
+		}
+		return max(n, 0)
+	}
+
+	order := make([]int, len(columns))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return columns[a].priority - columns[b].priority })
+
+	// drop optional columns until the rest fit when they're shrunk as far as
+	// they go
+	mins := make([]int, len(columns))
+	for i, col := range columns {
+		mins[i] = min(col.min, col.width)
+	}
+	for _, i := range order {
+		if total(mins) <= maxWidth {
+			break
+		}
+		if columns[i].optional {
+			widths[i], mins[i] = 0, 0
+		}
+	}
+
+	// then shrink the columns which are left, lowest priority first
+	for _, i := range order {
+		excess := total(widths) - maxWidth
+		if excess <= 0 {
+			break
+		}
+		widths[i] -= min(excess, widths[i]-mins[i])
+	}
+	return widths
+}
+
+// ellipsize returns s truncated to fit in w cells, ending with "…" if it was
+// cut short
+func ellipsize(s string, w int) string {
+	if width(s) <= w {
+		return s
+	}
+	if w <= 0 {
+		return ""
+	}
+	return truncate(s, w-1) + "…"
+}
+
+// wrapLine splits s into a line which fits in w cells, broken at the last
+// space that fits if there is one, and the rest of s
+func wrapLine(s string, w int) (string, string) {
+	if width(s) <= w {
+		return s, ""
+	}
+	line := truncate(s, w)
+	if !strings.HasPrefix(s[len(line):], " ") {
+		if space := strings.LastIndex(line, " "); space > 0 {
+			line = line[:space]
+		}
+	}
+	return line, strings.TrimLeft(s[len(line):], " ")
+}
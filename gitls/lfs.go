@@ -0,0 +1,131 @@
+package gitls
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// annotateLFS marks each file which is tracked by LFS
+func annotateLFS(repo Repository, dir string, files []*Entry) error {
+	var paths []string
+	for _, file := range files {
+		if !file.isDir {
+			paths = append(paths, file.entry.Name())
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	attrs, err := repo.CheckAttr(dir, paths)
+	if err != nil {
+		return err
+	}
+	lfs := lfsFiles(attrs)
+	if len(lfs) == 0 {
+		return nil
+	}
+	// a repository without a git directory has no local LFS objects, so all
+	// we can show are the pointers
+	lfsObjects := ""
+	if commonDir, err := CommonDir(dir); err == nil {
+		lfsObjects = filepath.Join(commonDir, "lfs", "objects")
+	}
+	parseLFS(files, lfs, lfsObjects)
+	return nil
+}
+
+// CheckAttr runs `git check-attr -z filter` on the paths in dir
+func (Git) CheckAttr(dir string, paths []string) ([]byte, error) {
+	cmd := gitCommand(dir, "check-attr", "-z", "--stdin", "filter")
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git attributes: %w", err)
+	}
+	return out, nil
+}
+
+// lfsFiles parses the output of `git check-attr -z filter` and returns the set
+// of paths which use the lfs filter. Each record has the form
+// "<path>\0filter\0<value>\0"
+func lfsFiles(attrs []byte) map[string]bool {
+	lfs := make(map[string]bool)
+	parts := strings.Split(string(attrs), "\x00")
+	for i := 0; i+2 < len(parts); i += 3 {
+		if parts[i+2] == "lfs" {
+			lfs[parts[i]] = true
+		}
+	}
+	return lfs
+}
+
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// parseLFSPointer parses the contents of a git LFS pointer file, and returns
+// the object id and size it points to. ok is false if the contents are not an
+// LFS pointer. The format is described at:
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+func parseLFSPointer(contents []byte) (oid string, size int64, ok bool) {
+	lines := strings.Split(string(contents), "\n")
+	if len(lines) == 0 || lines[0] != lfsPointerVersion {
+		return "", 0, false
+	}
+
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "oid":
+			oid = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", 0, false
+			}
+			size = n
+		}
+	}
+
+	return oid, size, oid != ""
+}
+
+// lfsPointerMaxSize is the largest a pointer file can be, per the spec
+const lfsPointerMaxSize = 1024
+
+// parseLFS marks each file which is tracked by LFS, and determines whether
+// its object is present locally. lfsObjects is the directory where git LFS
+// stores the objects it has downloaded, or "" if there isn't one.
+func parseLFS(files []*Entry, lfs map[string]bool, lfsObjects string) {
+	for _, file := range files {
+		if !lfs[file.entry.Name()] {
+			continue
+		}
+
+		stat, err := os.Stat(file.FullPath())
+		if err != nil {
+			continue
+		}
+
+		// if the file in the working tree is larger than a pointer can be,
+		// it must be the smudged contents of the object
+		file.lfs = &LFS{Present: true, Size: stat.Size()}
+		if stat.Size() > lfsPointerMaxSize {
+			continue
+		}
+
+		contents, err := os.ReadFile(file.FullPath())
+		if err != nil {
+			continue
+		}
+		if oid, size, ok := parseLFSPointer(contents); ok && len(oid) > 4 {
+			present := false
+			if lfsObjects != "" {
+				_, err := os.Stat(filepath.Join(lfsObjects, oid[0:2], oid[2:4], oid))
+				present = err == nil
+			}
+			file.lfs = &LFS{Present: present, Size: size}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package gitls
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkTemplates are the built-in templates for links to files, by name
+var linkTemplates = map[string]string{
+	"file":    "file://{hostname}{path}",
+	"vscode":  "vscode://file{path}",
+	"idea":    "idea://open?file={path}",
+	"zed":     "zed://file{path}",
+	"sublime": "subl://open?url=file://{path}",
+}
+
+// editorLinks maps the commands of editors to their built-in link template
+var editorLinks = map[string]string{
+	"code":          "vscode",
+	"code-insiders": "vscode",
+	"codium":        "vscode",
+	"idea":          "idea",
+	"goland":        "idea",
+	"pycharm":       "idea",
+	"webstorm":      "idea",
+	"zed":           "zed",
+	"subl":          "sublime",
+}
+
+// LinkTemplate returns the template for links to files with the given name.
+// The name of a built-in template, one of file, vscode, idea, zed or sublime,
+// returns that template, and "editor" returns the one for the editor in
+// $VISUAL or $EDITOR. Any other name is returned as it is, since it's a
+// template itself. In templates, {path} is replaced with the absolute path
+// of the file and {relpath} with its path relative to the root of the
+// repository, both escaped for use in a URL, and {hostname} with the name of
+// this host.
+func LinkTemplate(name string) string {
+	if name == "editor" {
+		name = "file"
+		for _, env := range []string{"VISUAL", "EDITOR"} {
+			if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+				if editor, ok := editorLinks[filepath.Base(fields[0])]; ok {
+					name = editor
+				}
+				break
+			}
+		}
+	}
+	if template, ok := linkTemplates[name]; ok {
+		return template
+	}
+	return name
+}
+
+// LoadRenderOptions returns the options for rendering a listing of dir,
+// configured for the repository that contains it: the forge of the remote,
+// which FindForge chooses, the references to link in commit messages, and
+// the templates for links to files and directories, which are set with
+// git-ls.fileLink and git-ls.dirLink. Directories use the file link if
+// git-ls.dirLink isn't set. If links isn't "", it's used for both instead.
+//
+// A link named "forge" links to the file's page on the forge, as it is on
+// the current branch, or at HEAD if it's detached. Files are linked to their
+// local path instead if the repository isn't on a forge.
+func LoadRenderOptions(repo Repository, dir string, remote string, links string) (RenderOptions, error) {
+	forge, err := FindForge(repo, dir, remote)
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	references, err := FindReferences(repo, dir)
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	out, err := repo.Config(dir, `^git-ls\.(filelink|dirlink)$`)
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	config := parseConfig(out)
+
+	fileLink, dirLink := config["git-ls.filelink"], config["git-ls.dirlink"]
+	if links != "" {
+		fileLink, dirLink = links, links
+	}
+	if dirLink == "" {
+		dirLink = fileLink
+	}
+
+	opts := RenderOptions{Forge: forge, References: references}
+	if opts.FileLink, err = opts.linkTemplate(repo, dir, fileLink, forge.FileURL); err != nil {
+		return RenderOptions{}, err
+	}
+	if opts.DirLink, err = opts.linkTemplate(repo, dir, dirLink, forge.DirURL); err != nil {
+		return RenderOptions{}, err
+	}
+	if strings.Contains(opts.FileLink+opts.DirLink, "{relpath}") {
+		if opts.Root, err = repo.Root(dir); err != nil {
+			return RenderOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// linkTemplate returns the template for links with the given name, where
+// forgeURL gives the forge's URL for a path at a revision
+func (opts *RenderOptions) linkTemplate(repo Repository, dir, name string, forgeURL func(rev, path string) string) (string, error) {
+	if name != "forge" {
+		if name == "" {
+			return "", nil
+		}
+		return LinkTemplate(name), nil
+	}
+	if opts.Forge == nil {
+		return "", nil
+	}
+	rev, err := repo.Branch(dir)
+	if err == nil && rev == "HEAD" {
+		rev, err = repo.Head(dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	return forgeURL(rev, "{relpath}"), nil
+}
+
+// fileLink returns the URL to link file to, from the render options'
+// templates
+func (opts *RenderOptions) fileLink(file *Entry, hostname string) string {
+	template := opts.FileLink
+	if file.isDir && opts.DirLink != "" {
+		template = opts.DirLink
+	}
+	if template == "" {
+		template = linkTemplates["file"]
+	}
+	replacements := []string{"{path}", escapePath(file.FullPath()), "{hostname}", hostname}
+	if strings.Contains(template, "{relpath}") {
+		// the root has no symlinks in it, so neither can the path
+		dir, err := filepath.EvalSymlinks(file.dir)
+		if err != nil {
+			dir = file.dir
+		}
+		relpath, err := filepath.Rel(opts.Root, filepath.Join(dir, file.entry.Name()))
+		if err != nil {
+			relpath = file.FullPath()
+		}
+		if relpath == "." {
+			relpath = ""
+		}
+		replacements = append(replacements, "{relpath}", escapePath(relpath))
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// queryEscaper escapes the characters which url.PathEscape leaves alone, but
+// which would end a path that's in the query of a URL, like idea's
+var queryEscaper = strings.NewReplacer("&", "%26", "+", "%2B", "=", "%3D", ";", "%3B")
+
+// escapePath escapes each element of a path for use in a URL, in its path or
+// its query
+func escapePath(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		parts[i] = queryEscaper.Replace(url.PathEscape(part))
+	}
+	return strings.Join(parts, "/")
+}
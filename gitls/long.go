@@ -0,0 +1,219 @@
+package gitls
+
+import (
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Stat is the information about a file in the file system which a long
+// listing shows, like `ls -l`
+type Stat struct {
+	Mode    os.FileMode
+	Owner   string
+	Group   string
+	Size    int64
+	ModTime time.Time
+	// GitMode is the mode git records for the file in the index, like
+	// 0100644 or 0100755, or 0 if it isn't tracked
+	GitMode uint32
+}
+
+// the modes git records for regular files
+const (
+	gitModeFile       = 0100644
+	gitModeExecutable = 0100755
+)
+
+// ModeChanged returns true if the file's executable bit differs from the
+// mode git records for it. git doesn't report these changes if
+// core.fileMode is false.
+func (s *Stat) ModeChanged() bool {
+	if s.GitMode != gitModeFile && s.GitMode != gitModeExecutable {
+		return false
+	}
+	return s.Mode.IsRegular() && (s.Mode&0111 != 0) != (s.GitMode == gitModeExecutable)
+}
+
+// annotateStat sets the file system information of each file, and the mode
+// git records for it
+func annotateStat(repo Repository, dir string, files []*Entry) error {
+	var modes map[string]uint32
+	if slices.ContainsFunc(files, func(file *Entry) bool { return !file.isDir }) {
+		out, err := repo.IndexModes(dir)
+		if err != nil {
+			return err
+		}
+		modes = parseIndexModes(out)
+	}
+
+	owners := newIDNames(func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	groups := newIDNames(func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+	for _, file := range files {
+		// like ls, describe symlinks themselves rather than their targets
+		info, err := os.Lstat(file.FullPath())
+		if err != nil {
+			continue
+		}
+		stat := &Stat{
+			Mode:    info.Mode(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			GitMode: modes[file.entry.Name()],
+		}
+		if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+			stat.Owner = owners.name(sys.Uid)
+			stat.Group = groups.name(sys.Gid)
+		}
+		file.stat = stat
+	}
+	return nil
+}
+
+// idNames looks up the names of user or group ids, and remembers them, since
+// a directory's files usually all have the same owner
+type idNames struct {
+	lookup func(id string) (string, error)
+	names  map[uint32]string
+}
+
+func newIDNames(lookup func(id string) (string, error)) *idNames {
+	return &idNames{lookup: lookup, names: make(map[uint32]string)}
+}
+
+// name returns the name for id, or the id itself if it has no name
+func (n *idNames) name(id uint32) string {
+	if name, ok := n.names[id]; ok {
+		return name
+	}
+	name, err := n.lookup(strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		name = strconv.FormatUint(uint64(id), 10)
+	}
+	n.names[id] = name
+	return name
+}
+
+// parseIndexModes parses the output of `git ls-files --stage -z`, which has a
+// record of "<mode> <object> <stage>\t<path>\0" for each file, and returns the
+// mode of each path. Paths in subdirectories are skipped.
+func parseIndexModes(out []byte) map[string]uint32 {
+	modes := make(map[string]uint32)
+	for _, record := range strings.Split(string(out), "\x00") {
+		info, path, ok := strings.Cut(record, "\t")
+		if !ok || strings.Contains(path, "/") {
+			continue
+		}
+		mode, _, _ := strings.Cut(info, " ")
+		n, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			continue
+		}
+		// a conflicted file has an entry for each stage, which all have the
+		// same path
+		if _, ok := modes[path]; !ok {
+			modes[path] = uint32(n)
+		}
+	}
+	return modes
+}
+
+// modeString returns the mode the way `ls -l` shows it, like "-rwxr-xr-x"
+func modeString(mode os.FileMode) string {
+	var b strings.Builder
+	switch {
+	case mode.IsDir():
+		b.WriteByte('d')
+	case mode&os.ModeSymlink != 0:
+		b.WriteByte('l')
+	case mode&os.ModeNamedPipe != 0:
+		b.WriteByte('p')
+	case mode&os.ModeSocket != 0:
+		b.WriteByte('s')
+	case mode&os.ModeCharDevice != 0:
+		b.WriteByte('c')
+	case mode&os.ModeDevice != 0:
+		b.WriteByte('b')
+	default:
+		b.WriteByte('-')
+	}
+
+	// the special bits replace the execute bit of the owner, group or
+	// others, in lowercase if it's set and uppercase if it isn't
+	special := []struct {
+		bit  os.FileMode
+		char byte
+	}{
+		{os.ModeSetuid, 's'},
+		{os.ModeSetgid, 's'},
+		{os.ModeSticky, 't'},
+	}
+	for i, s := range special {
+		perm := mode.Perm() >> (3 * (2 - i))
+		for j, c := range "rw" {
+			if perm&(4>>j) != 0 {
+				b.WriteRune(c)
+			} else {
+				b.WriteByte('-')
+			}
+		}
+		switch {
+		case mode&s.bit != 0 && perm&1 != 0:
+			b.WriteByte(s.char)
+		case mode&s.bit != 0:
+			b.WriteByte(s.char - 'a' + 'A')
+		case perm&1 != 0:
+			b.WriteByte('x')
+		default:
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+// modeLabel returns the mode shown for a file in a long listing. If its
+// executable bit differs from git's, it's followed by "+x" if the file is
+// executable but git records it as not, or "-x" for the reverse.
+func modeLabel(stat *Stat) string {
+	if stat == nil {
+		return ""
+	}
+	label := modeString(stat.Mode)
+	if stat.ModeChanged() {
+		if stat.Mode&0111 != 0 {
+			return label + " +x"
+		}
+		return label + " -x"
+	}
+	return label
+}
+
+// sixMonths is how old a file can be before `ls -l` shows the year it was
+// modified instead of the time
+const sixMonths = 182 * 24 * time.Hour
+
+// modTime returns the time a file was modified the way `ls -l` shows it:
+// the date and time if it was in the last six months, or the date and year
+// otherwise
+func modTime(t, now time.Time) string {
+	if t.After(now) || now.Sub(t) > sixMonths {
+		return t.Format("Jan _2  2006")
+	}
+	return t.Format("Jan _2 15:04")
+}
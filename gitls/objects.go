@@ -0,0 +1,566 @@
+package gitls
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// oid is a SHA-1 object id
+type oid [20]byte
+
+func (id oid) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func parseOID(s string) (oid, error) {
+	var id oid
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("invalid object id %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// git object types, as they're numbered in pack files
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var objTypes = map[string]int{
+	"commit": objCommit,
+	"tree":   objTree,
+	"blob":   objBlob,
+	"tag":    objTag,
+}
+
+// maxCachedObjects limits the number of objects kept in memory. Trees and
+// commits are small, and delta chains often share bases, so caching them
+// saves a lot of decompression.
+const maxCachedObjects = 16384
+
+type object struct {
+	typ  int
+	data []byte
+}
+
+// objectStore reads objects directly from a repository's loose object
+// directories and pack files
+type objectStore struct {
+	dirs  []string
+	packs []*packFile
+
+	mu    sync.Mutex
+	cache map[oid]object
+}
+
+// openObjectStore opens the objects in objectsDir, and in any alternate
+// object directories it lists
+func openObjectStore(objectsDir string) (*objectStore, error) {
+	s := &objectStore{cache: make(map[oid]object)}
+	s.dirs = append(s.dirs, objectsDir)
+	if alternates, err := os.ReadFile(filepath.Join(objectsDir, "info", "alternates")); err == nil {
+		for _, line := range strings.Split(string(alternates), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if !filepath.IsAbs(line) {
+				line = filepath.Join(objectsDir, line)
+			}
+			s.dirs = append(s.dirs, line)
+		}
+	}
+
+	for _, dir := range s.dirs {
+		idxs, _ := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		for _, idx := range idxs {
+			pack, err := openPack(idx)
+			if err != nil {
+				s.close()
+				return nil, err
+			}
+			s.packs = append(s.packs, pack)
+		}
+	}
+	return s, nil
+}
+
+func (s *objectStore) close() {
+	for _, pack := range s.packs {
+		pack.close()
+	}
+}
+
+// read returns the type and contents of an object
+func (s *objectStore) read(id oid) (int, []byte, error) {
+	s.mu.Lock()
+	obj, ok := s.cache[id]
+	s.mu.Unlock()
+	if ok {
+		return obj.typ, obj.data, nil
+	}
+
+	typ, data, err := s.readUncached(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s.mu.Lock()
+	if len(s.cache) >= maxCachedObjects {
+		clear(s.cache)
+	}
+	s.cache[id] = object{typ, data}
+	s.mu.Unlock()
+	return typ, data, nil
+}
+
+func (s *objectStore) readUncached(id oid) (int, []byte, error) {
+	for _, pack := range s.packs {
+		if offset, ok := pack.find(id); ok {
+			return pack.read(s, offset)
+		}
+	}
+	for _, dir := range s.dirs {
+		hexID := id.String()
+		f, err := os.Open(filepath.Join(dir, hexID[:2], hexID[2:]))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		return readLoose(f)
+	}
+	return 0, nil, fmt.Errorf("object %s not found", id)
+}
+
+// readLoose reads a loose object, which is a zlib-compressed header of
+// "<type> <size>\0" followed by the object's contents
+func readLoose(r io.Reader) (int, []byte, error) {
+	z, err := zlib.NewReader(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer z.Close()
+	br := bufio.NewReader(z)
+	header, err := br.ReadString(0)
+	if err != nil {
+		return 0, nil, err
+	}
+	name, sizeStr, _ := strings.Cut(strings.TrimSuffix(header, "\x00"), " ")
+	typ, ok := objTypes[name]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown object type %q", name)
+	}
+	var size int
+	if _, err := fmt.Sscan(sizeStr, &size); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+// readTyped reads an object and checks that it's of the expected type
+func (s *objectStore) readTyped(id oid, typ int) ([]byte, error) {
+	actual, data, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if actual != typ {
+		return nil, fmt.Errorf("object %s has type %d, expected %d", id, actual, typ)
+	}
+	return data, nil
+}
+
+// count returns the number of objects in the pack files, which git uses to
+// decide how long abbreviated hashes should be
+func (s *objectStore) count() int {
+	n := 0
+	for _, pack := range s.packs {
+		n += pack.n
+	}
+	return n
+}
+
+// ambiguous returns true if more than one object id starts with prefix,
+// which must be a hex string
+func (s *objectStore) ambiguous(prefix string) bool {
+	matches := 0
+	for _, pack := range s.packs {
+		matches += pack.countPrefix(prefix, 2-matches)
+		if matches > 1 {
+			return true
+		}
+	}
+	for _, dir := range s.dirs {
+		entries, _ := os.ReadDir(filepath.Join(dir, prefix[:2]))
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), prefix[2:]) {
+				matches++
+			}
+		}
+	}
+	// an object can be both packed and loose, so this may count an object
+	// twice and make abbreviations a character longer than needed, which is
+	// harmless
+	return matches > 1
+}
+
+// packFile is a pack file and its version 2 index. The index is a fanout
+// table of 256 cumulative counts by first byte, followed by the sorted object
+// ids, their CRCs, their 4-byte offsets and finally any 8-byte offsets.
+type packFile struct {
+	idx  []byte
+	pack *os.File
+	n    int
+
+	names   []byte
+	offsets []byte
+	large   []byte
+
+	mu     sync.Mutex
+	deltas map[int64]object
+}
+
+func openPack(idxPath string) (*packFile, error) {
+	idx, err := mmapFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		_ = syscall.Munmap(idx)
+		return nil, fmt.Errorf("%s: unsupported pack index version", idxPath)
+	}
+
+	n := int(binary.BigEndian.Uint32(idx[8+255*4:]))
+	namesStart := 8 + 256*4
+	offsetsStart := namesStart + n*20 + n*4
+	if len(idx) < offsetsStart+n*4 {
+		_ = syscall.Munmap(idx)
+		return nil, fmt.Errorf("%s: truncated pack index", idxPath)
+	}
+
+	pack, err := os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		_ = syscall.Munmap(idx)
+		return nil, err
+	}
+
+	return &packFile{
+		idx:     idx,
+		pack:    pack,
+		n:       n,
+		names:   idx[namesStart : namesStart+n*20],
+		offsets: idx[offsetsStart : offsetsStart+n*4],
+		large:   idx[offsetsStart+n*4:],
+		deltas:  make(map[int64]object),
+	}, nil
+}
+
+func (p *packFile) close() {
+	_ = syscall.Munmap(p.idx)
+	p.pack.Close()
+}
+
+func (p *packFile) name(i int) []byte {
+	return p.names[i*20 : i*20+20]
+}
+
+// find returns the offset of an object in the pack
+func (p *packFile) find(id oid) (int64, bool) {
+	lo, hi := fanoutRange(p.idx[8:], id[0])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.name(lo+i), id[:]) >= 0
+	})
+	if i >= hi || !bytes.Equal(p.name(i), id[:]) {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	large := int(offset&0x7fffffff) * 8
+	if large+8 > len(p.large) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.large[large:])), true
+}
+
+// countPrefix counts the objects in the pack whose ids start with the hex
+// string prefix, stopping once it has found limit of them
+func (p *packFile) countPrefix(prefix string, limit int) int {
+	first, err := hex.DecodeString(prefix[:2])
+	if err != nil {
+		return 0
+	}
+	lo, hi := fanoutRange(p.idx[8:], first[0])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return hex.EncodeToString(p.name(lo+i)) >= prefix
+	})
+	matches := 0
+	for ; i < hi && matches < limit && strings.HasPrefix(hex.EncodeToString(p.name(i)), prefix); i++ {
+		matches++
+	}
+	return matches
+}
+
+// fanoutRange returns the range of indexes of the ids which start with the
+// byte first, given a fanout table of 256 cumulative big-endian counts
+func fanoutRange(fanout []byte, first byte) (int, int) {
+	lo := 0
+	if first > 0 {
+		lo = int(binary.BigEndian.Uint32(fanout[(int(first)-1)*4:]))
+	}
+	return lo, int(binary.BigEndian.Uint32(fanout[int(first)*4:]))
+}
+
+// read reads the object at offset, applying deltas to reconstruct it if
+// necessary
+func (p *packFile) read(s *objectStore, offset int64) (int, []byte, error) {
+	p.mu.Lock()
+	obj, ok := p.deltas[offset]
+	p.mu.Unlock()
+	if ok {
+		return obj.typ, obj.data, nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(p.pack, offset, math.MaxInt64-offset))
+
+	// each entry starts with a variable-length header containing its type
+	// and inflated size
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := int(b>>4) & 7
+	size := int64(b & 0x0f)
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(b&0x7f) << shift
+	}
+
+	var baseTyp int
+	var base []byte
+	switch typ {
+	case objCommit, objTree, objBlob, objTag:
+		data, err := inflate(r, size)
+		return typ, data, err
+	case objOfsDelta:
+		// the base's offset is relative to this entry, in a big-endian
+		// variable-length encoding where each continuation adds one
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = r.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if rel <= 0 || rel > offset {
+			return 0, nil, errors.New("invalid delta base offset")
+		}
+		baseTyp, base, err = p.read(s, offset-rel)
+		if err != nil {
+			return 0, nil, err
+		}
+	case objRefDelta:
+		var baseID oid
+		if _, err := io.ReadFull(r, baseID[:]); err != nil {
+			return 0, nil, err
+		}
+		baseTyp, base, err = s.read(baseID)
+		if err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("unknown pack object type %d", typ)
+	}
+
+	delta, err := inflate(r, size)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := applyDelta(base, delta)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	p.mu.Lock()
+	if len(p.deltas) >= maxCachedObjects {
+		clear(p.deltas)
+	}
+	p.deltas[offset] = object{baseTyp, data}
+	p.mu.Unlock()
+	return baseTyp, data, nil
+}
+
+// inflate decompresses size bytes from a zlib stream
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	z, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(z, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// applyDelta reconstructs an object from its base and a delta. A delta
+// starts with the sizes of the base and result, followed by instructions
+// which either copy a range of the base or insert new data.
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	errInvalid := errors.New("invalid delta")
+
+	varint := func() (int, bool) {
+		n, shift := 0, 0
+		for len(delta) > 0 {
+			b := delta[0]
+			delta = delta[1:]
+			n |= int(b&0x7f) << shift
+			shift += 7
+			if b&0x80 == 0 {
+				return n, true
+			}
+		}
+		return 0, false
+	}
+
+	baseSize, ok := varint()
+	if !ok || baseSize != len(base) {
+		return nil, errInvalid
+	}
+	size, ok := varint()
+	if !ok {
+		return nil, errInvalid
+	}
+
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// copy: the low bits say which bytes of the offset and size
+			// follow
+			var offset, n int
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errInvalid
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					n |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > len(base) {
+				return nil, errInvalid
+			}
+			out = append(out, base[offset:offset+n]...)
+		case op != 0:
+			// insert the next op bytes of the delta
+			if int(op) > len(delta) {
+				return nil, errInvalid
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errInvalid
+		}
+	}
+
+	if len(out) != size {
+		return nil, errInvalid
+	}
+	return out, nil
+}
+
+// mmapFile maps a file into memory read-only
+func mmapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// treeEntry is an entry in a tree object
+type treeEntry struct {
+	mode uint32
+	name string
+	id   oid
+}
+
+// parseTree parses a tree object, which is a list of entries of the form
+// "<octal mode> <name>\0<20 byte id>"
+func parseTree(data []byte) ([]treeEntry, error) {
+	var entries []treeEntry
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			return nil, errors.New("invalid tree entry")
+		}
+		var mode uint32
+		for _, c := range data[:space] {
+			if c < '0' || c > '7' {
+				return nil, errors.New("invalid tree entry mode")
+			}
+			mode = mode<<3 | uint32(c-'0')
+		}
+		data = data[space+1:]
+
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 || len(data) < nul+1+20 {
+			return nil, errors.New("invalid tree entry")
+		}
+		entry := treeEntry{mode: mode, name: string(data[:nul])}
+		copy(entry.id[:], data[nul+1:])
+		entries = append(entries, entry)
+		data = data[nul+1+20:]
+	}
+	return entries, nil
+}
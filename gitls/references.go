@@ -0,0 +1,103 @@
+package gitls
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Reference is a pattern for references in commit messages, like the keys
+// of Jira issues, and the URL that they link to
+type Reference struct {
+	Pattern *regexp.Regexp
+	// URL is a template for the link, in which {0} is replaced by the whole
+	// match, {1} and up by the pattern's groups, and {host}, {owner} and
+	// {repo} by the fields of the repository's forge
+	URL string
+}
+
+// FindReferences returns the reference patterns configured for the
+// repository containing dir with git-ls.reference.<name>.pattern and
+// git-ls.reference.<name>.url, ordered by name
+func FindReferences(repo Repository, dir string) ([]Reference, error) {
+	out, err := repo.Config(dir, `^git-ls\.reference\.`)
+	if err != nil {
+		return nil, err
+	}
+	return parseReferences(parseConfig(out))
+}
+
+// parseReferences returns the references in the config options
+func parseReferences(config map[string]string) ([]Reference, error) {
+	var names []string
+	for key := range config {
+		name, ok := strings.CutPrefix(key, "git-ls.reference.")
+		if name, ok = strings.CutSuffix(name, ".pattern"); ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var references []Reference
+	for _, name := range names {
+		prefix := "git-ls.reference." + name
+		pattern, err := regexp.Compile(config[prefix+".pattern"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %s: %w", prefix, err)
+		}
+		url, ok := config[prefix+".url"]
+		if !ok {
+			return nil, fmt.Errorf("%s.url is not set", prefix)
+		}
+		references = append(references, Reference{Pattern: pattern, URL: url})
+	}
+	return references, nil
+}
+
+// reference is a match of a Reference in a commit message
+type reference struct {
+	start, end int
+	url        string
+}
+
+// findReferences returns the non-overlapping matches of the references in
+// msg, followed by the references to the forge's issues and pull requests, in
+// order. Where matches overlap, the one which starts first wins, and then the
+// one whose pattern comes first.
+func findReferences(msg string, references []Reference, forge *Forge) []reference {
+	var matches []reference
+	for _, ref := range references {
+		for _, match := range ref.Pattern.FindAllStringSubmatchIndex(msg, -1) {
+			if match[0] == match[1] {
+				continue
+			}
+			replacements := []string{"{host}", "", "{owner}", "", "{repo}", ""}
+			if forge != nil {
+				replacements = []string{"{host}", forge.Host, "{owner}", forge.Owner, "{repo}", forge.Repo}
+			}
+			for i := 0; i < len(match)/2; i++ {
+				group := ""
+				if match[2*i] >= 0 {
+					group = msg[match[2*i]:match[2*i+1]]
+				}
+				replacements = append(replacements, "{"+strconv.Itoa(i)+"}", group)
+			}
+			url := strings.NewReplacer(replacements...).Replace(ref.URL)
+			matches = append(matches, reference{start: match[0], end: match[1], url: url})
+		}
+	}
+	matches = append(matches, forge.forgeReferences(msg)...)
+
+	// the sort is stable, so matches with the same start stay in the order
+	// of their patterns
+	slices.SortStableFunc(matches, func(a, b reference) int { return a.start - b.start })
+	var found []reference
+	for _, match := range matches {
+		if len(found) == 0 || match.start >= found[len(found)-1].end {
+			found = append(found, match)
+		}
+	}
+	return found
+}
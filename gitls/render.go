@@ -0,0 +1,396 @@
+package gitls
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BLUE   = "\x1b[34m"
+	GREEN  = "\x1b[32m"
+	RED    = "\x1b[31m"
+	RESET  = "\x1b[0m"
+	YELLOW = "\x1b[33m"
+)
+
+// Link returns name as an OSC8 hyperlink to url
+func Link(url string, name string) string {
+	// hyperlink format: \e]8;;<url>\e\<link text>\e]8;;\e\
+	return fmt.Sprintf("\x1b]8;;%s\x1b\\%s\x1b]8;;\x1b\\", url, name)
+}
+
+// linkify links a commit message to the commit on its forge, and the
+// references within it to their URLs. References to the forge's issues and
+// pull requests are linked after any configured references.
+func linkify(commitMsg string, forge *Forge, references []Reference, hash string) string {
+	commitUrl := forge.CommitURL(hash)
+	linkText := func(text string) string {
+		if commitUrl == "" || text == "" {
+			return text
+		}
+		return Link(commitUrl, text)
+	}
+
+	out := make([]string, 0, 16)
+	last := 0
+	for _, ref := range findReferences(commitMsg, references, forge) {
+		out = append(out, linkText(commitMsg[last:ref.start]))
+		refText := fmt.Sprintf("%s%s%s", BLUE, commitMsg[ref.start:ref.end], RESET)
+		out = append(out, Link(ref.url, refText))
+		last = ref.end
+	}
+	out = append(out, linkText(commitMsg[last:]))
+
+	return strings.Join(out, "")
+}
+
+// Pulled straight from git:
+// https://github.com/git/git/blob/d4cc1ec3/diff.c#L2862-L2874
+func scale_linear(n int, width int, max_change int) int {
+	if n == 0 {
+		return 0
+	}
+	/*
+	 * make sure that at least one '-' or '+' is printed if
+	 * there is any change to this path. The easiest way is to
+	 * scale linearly as if the allotted width is one column shorter
+	 * than it is, and then add 1 to the result.
+	 */
+	return 1 + (n * (width - 1) / max_change)
+}
+
+// makeDiffGraph turns the total diff for a file/directory into a diff graph
+// string.
+func makeDiffGraph(file *Entry, width int) string {
+	if file.diffSum == nil {
+		return ""
+	}
+	plus := file.diffSum.Plus
+	minus := file.diffSum.Minus
+	var graph string
+	if plus+minus <= width {
+		graph = fmt.Sprintf("%s%s%s%s%s",
+			GREEN,
+			strings.Repeat("+", plus),
+			RED,
+			strings.Repeat("-", minus),
+			RESET)
+	} else {
+		graph = fmt.Sprintf("%s%s%s%s%s",
+			GREEN,
+			strings.Repeat("+", scale_linear(plus, width, plus+minus)),
+			RED,
+			strings.Repeat("-", scale_linear(minus, width, plus+minus)),
+			RESET)
+	}
+
+	// numstat has no line counts for binary files, so append a marker and
+	// the change in their size
+	if file.diffSum.Binary > 0 {
+		if plus+minus > 0 {
+			graph += " "
+		}
+		graph += binaryDiffLabel(file.diffSum.SizeDelta)
+	}
+	return graph
+}
+
+// binaryDiffLabel returns a marker for changed binary files, with the
+// change in size if there is one
+func binaryDiffLabel(delta int64) string {
+	switch {
+	case delta > 0:
+		return fmt.Sprintf("bin %s+%s%s", GREEN, humanSize(delta), RESET)
+	case delta < 0:
+		return fmt.Sprintf("bin %s-%s%s", RED, humanSize(-delta), RESET)
+	default:
+		return "bin"
+	}
+}
+
+// lfsLabel returns the marker shown for an LFS file: "lfs" and its size if
+// the object is present locally, or "ptr" if we only have the pointer
+func lfsLabel(lfs *LFS) string {
+	if lfs == nil {
+		return ""
+	}
+	if lfs.Present {
+		return "lfs " + humanSize(lfs.Size)
+	}
+	return "ptr " + humanSize(lfs.Size)
+}
+
+// RenderOptions controls how a listing is rendered
+type RenderOptions struct {
+	// Width is the width of the terminal. Lines are cut short at this width
+	Width int
+	// Forge is the site hosting the repository, if it has one. When it's
+	// set, authors and commit messages are linked to it
+	Forge *Forge
+	// References are linked wherever they appear in commit messages
+	References []Reference
+	// FileLink is the template for links to files, and DirLink for links to
+	// directories. See LinkTemplate for the templates. Files link to their
+	// file:// URL if FileLink is "", and directories link like files if
+	// DirLink is "".
+	FileLink string
+	DirLink  string
+	// Root is the root of the repository, which {relpath} in the link
+	// templates is relative to
+	Root string
+	// Wrap puts the rest of each commit message which doesn't fit in the
+	// width on a second line, rather than cutting it short
+	Wrap bool
+	// Icons shows a Nerd Font icon for the type of each entry before its
+	// name
+	Icons bool
+}
+
+// the columns of the listing, in the order they're shown
+const (
+	colMode = iota
+	colOwner
+	colGroup
+	colFileSize
+	colModTime
+	colStatus
+	colName
+	colLFS
+	colSize
+	colLines
+	colDate
+	colAuthor
+	colBlame
+	colMessage
+	numColumns
+)
+
+// longColumns are the columns of a long listing
+var longColumns = []int{colMode, colOwner, colGroup, colFileSize, colModTime}
+
+// longField returns the contents of one of the long columns for a file with
+// the given stat, which may be nil
+func longField(stat *Stat, col int, now time.Time) string {
+	if stat == nil {
+		return ""
+	}
+	switch col {
+	case colMode:
+		return modeLabel(stat)
+	case colOwner:
+		return stat.Owner
+	case colGroup:
+		return stat.Group
+	case colFileSize:
+		return strconv.FormatInt(stat.Size, 10)
+	case colModTime:
+		return modTime(stat.ModTime, now)
+	}
+	return ""
+}
+
+// Render writes the entries to out as a table, one entry per line, with
+// the same columns as the git-ls command. If the columns don't fit in the
+// width, commit messages shrink first, then blame, authors and file names,
+// and they're cut short with "…".
+func Render(out io.Writer, entries []Entry, opts RenderOptions) {
+	maxWidth := opts.Width
+	forge := opts.Forge
+	hostname, _ := os.Hostname()
+	now := time.Now()
+
+	maxStatus := 0
+	maxDiffStat := 0
+	columns := make([]column, numColumns)
+	for _, file := range entries {
+		for _, col := range longColumns {
+			columns[col].width = max(columns[col].width, width(longField(file.stat, col, now)))
+		}
+		maxStatus = max(maxStatus, len(file.status))
+		maxDiffStat = max(maxDiffStat, width(file.diffStat))
+		columns[colName].width = max(columns[colName].width, width(file.DisplayName()))
+		columns[colLFS].width = max(columns[colLFS].width, len(lfsLabel(file.lfs)))
+		columns[colSize].width = max(columns[colSize].width, len(file.size))
+		columns[colLines].width = max(columns[colLines].width, len(file.lines))
+		columns[colDate].width = max(columns[colDate].width, width(dateLabel(&file)))
+		columns[colAuthor].width = max(columns[colAuthor].width, width(file.author))
+		columns[colBlame].width = max(columns[colBlame].width, width(blameLabel(&file)))
+		columns[colMessage].width = max(columns[colMessage].width, width(file.message))
+	}
+	// the status and the diffstat are shown together if there are any
+	// modified files
+	if maxStatus > 0 {
+		columns[colStatus].width = maxStatus + 1 + maxDiffStat
+	}
+	for i := range columns {
+		columns[i].min = columns[i].width
+	}
+	columns[colName].min, columns[colName].priority = 8, 4
+	// the icons go in the name column, so that names are cut short to make
+	// room for them
+	icons := 0
+	if opts.Icons {
+		icons = iconWidth
+		columns[colName].width += icons
+		columns[colName].min += icons
+	}
+	columns[colAuthor] = column{width: columns[colAuthor].width, min: 4, priority: 3, optional: true}
+	columns[colBlame] = column{width: columns[colBlame].width, min: 6, priority: 2, optional: true}
+	columns[colMessage] = column{width: columns[colMessage].width, min: 12, priority: 1, optional: true}
+
+	// without a width, when the output isn't a terminal, the columns which
+	// are always shown aren't cut short, and the rest are left off
+	var widths []int
+	if maxWidth > 0 {
+		widths = layout(columns, maxWidth)
+	} else {
+		for _, col := range columns {
+			widths = append(widths, col.width)
+		}
+		widths[colAuthor], widths[colBlame], widths[colMessage] = 0, 0, 0
+	}
+
+	for _, file := range entries {
+		// lineWidth tracks the width of the current line
+		lineWidth := 0
+
+		// in a long listing, start with the fields `ls -l` shows. The mode is
+		// red if the executable bit differs from the one in git
+		for _, col := range longColumns {
+			if widths[col] == 0 {
+				continue
+			}
+			cell := pad(longField(file.stat, col, now), widths[col])
+			if col == colFileSize {
+				cell = fmt.Sprintf("%*s", widths[col], longField(file.stat, col, now))
+			}
+			if col == colMode && file.stat != nil && file.stat.ModeChanged() {
+				cell = RED + cell + RESET
+			}
+			fmt.Fprintf(out, "%s ", cell)
+			lineWidth += widths[col] + 1
+		}
+
+		// print the file's git status and its diffstat summary. If there are
+		// no modified files, skip them entirely
+		if widths[colStatus] > 0 {
+			fmt.Fprintf(out, "%*s %s", maxStatus, file.status, file.diffStat)
+			fmt.Fprintf(out, "%s ", strings.Repeat(" ", maxDiffStat-width(file.diffStat)))
+			lineWidth += widths[colStatus] + 1
+		}
+
+		if file.isDir {
+			fmt.Fprintf(out, "%s", BLUE)
+		}
+		if file.isExe {
+			fmt.Fprintf(out, "%s", GREEN)
+		}
+		if opts.Icons {
+			fmt.Fprintf(out, "%s ", icon(&file))
+		}
+		// link the file name to the file's location, and pad spaces to the
+		// right up to the width of the column
+		name := ellipsize(file.DisplayName(), widths[colName]-icons)
+		fmt.Fprintf(out, "%s", Link(opts.fileLink(&file, hostname), name))
+		fmt.Fprintf(out, "%s", strings.Repeat(" ", widths[colName]-icons-width(name)))
+		if file.isDir || file.isExe {
+			fmt.Fprintf(out, "%s", RESET)
+		}
+		lineWidth += widths[colName]
+
+		// if there are any LFS files, mark them and show the object size
+		if widths[colLFS] > 0 {
+			if file.lfs != nil && !file.lfs.Present {
+				fmt.Fprintf(out, " %s%*s%s", RED, widths[colLFS], lfsLabel(file.lfs), RESET)
+			} else {
+				fmt.Fprintf(out, " %*s", widths[colLFS], lfsLabel(file.lfs))
+			}
+			lineWidth += widths[colLFS] + 1
+		}
+
+		// print the size and line count columns if they were requested
+		if widths[colSize] > 0 {
+			fmt.Fprintf(out, " %*s", widths[colSize], file.size)
+			lineWidth += widths[colSize] + 1
+		}
+		if widths[colLines] > 0 {
+			fmt.Fprintf(out, " %*s", widths[colLines], file.lines)
+			lineWidth += widths[colLines] + 1
+		}
+
+		// write the last modified date, or an ellipsis if we gave up on
+		// finding it, padded so that the columns after it line up
+		date := dateLabel(&file)
+		if widths[colAuthor] > 0 || widths[colBlame] > 0 || widths[colMessage] > 0 {
+			date = pad(date, widths[colDate])
+		}
+		fmt.Fprintf(out, " %s", date)
+		lineWidth += width(date) + 1
+
+		if widths[colAuthor] > 0 {
+			author := ellipsize(file.author, widths[colAuthor])
+			lineWidth += width(author) + 1
+			if authorLink := forge.AuthorURL(file.authorEmail); authorLink != "" {
+				// if the repo is on a forge, link the author name to their
+				// commits page there. It would be cool to hyperlink the author
+				// to a git command, but I'm not sure how to give a URL for the
+				// command `git log --author=Janet`
+				fmt.Fprintf(out, " %s%s%s", YELLOW, Link(authorLink, author), RESET)
+			} else {
+				fmt.Fprintf(out, " %s%s%s", YELLOW, author, RESET)
+			}
+		}
+
+		// if we have blame info, show the author who owns most of the file's
+		// lines next to the last committer
+		if blame := blameLabel(&file); widths[colBlame] > 0 && blame != "" {
+			blame = ellipsize(blame, widths[colBlame])
+			lineWidth += width(blame) + 1
+			fmt.Fprintf(out, " %s%s%s", YELLOW, blame, RESET)
+		}
+
+		if widths[colMessage] == 0 {
+			fmt.Fprintln(out, "")
+			continue
+		}
+		// Link the commit message to its commit, and the references in it to
+		// wherever they point. With Wrap, the rest of a message which doesn't
+		// fit goes on a second line, lined up under the first.
+		message, rest := file.message, ""
+		if opts.Wrap {
+			message, rest = wrapLine(message, widths[colMessage])
+		}
+		message = ellipsize(message, widths[colMessage])
+		fmt.Fprintf(out, " %s\n", linkify(message, forge, opts.References, file.hash))
+		if rest != "" {
+			rest = ellipsize(rest, widths[colMessage])
+			fmt.Fprintf(out, "%*s%s\n", lineWidth+1, "", linkify(rest, forge, opts.References, file.hash))
+		}
+	}
+}
+
+// dateLabel returns the last modified date shown for file, or an ellipsis if
+// we gave up on finding it
+func dateLabel(file *Entry) string {
+	if file.logTimedOut {
+		return "…"
+	}
+	return file.lastModified
+}
+
+// blameLabel returns the blame shown for file: the author who owns most of
+// its lines and the percentage they own, an ellipsis if blame timed out, or
+// "" if there is no blame for it
+func blameLabel(file *Entry) string {
+	switch {
+	case file.blame != nil:
+		return fmt.Sprintf("%s %d%%", file.blame.Author, file.blame.Percent)
+	case file.blameTimedOut:
+		return "…"
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package gitls
+
+import "context"
+
+// Repository is where a listing gets its git information from. Each method
+// returns the output of the git command it describes, so that every backend
+// shares the same parsing. Git runs the git command, and tests use an
+// in-memory fake.
+type Repository interface {
+	// Root returns the root directory of the repository containing dir
+	Root(dir string) (string, error)
+	// Branch returns the name of the current branch of the repository
+	// containing dir, or "HEAD" if HEAD is detached
+	Branch(dir string) (string, error)
+	// Head returns the hash of the commit HEAD points to
+	Head(dir string) (string, error)
+	// Remotes returns the output of `git remote -v`
+	Remotes(dir string) ([]byte, error)
+	// Config returns the output of `git config -z --get-regexp pattern`,
+	// which is empty if no options match
+	Config(dir string, pattern string) ([]byte, error)
+	// Status returns the output of `git status --porcelain --ignored`
+	Status(dir string) ([]byte, error)
+	// NumStat returns the output of `git diff --numstat --relative HEAD`
+	NumStat(dir string) ([]byte, error)
+	// Log returns the last commit to touch file, as its abbreviated hash,
+	// date, author name, author email and subject separated by NULs. It
+	// returns nothing if no commit has touched the file.
+	Log(ctx context.Context, file *Entry) ([]byte, error)
+	// Blame returns the output of `git blame --porcelain` for file
+	Blame(ctx context.Context, file *Entry) ([]byte, error)
+	// CheckAttr returns the output of `git check-attr -z filter` for the
+	// paths, which are relative to dir
+	CheckAttr(dir string, paths []string) ([]byte, error)
+	// HeadSizes returns the output of `git cat-file --batch-check` for each
+	// path as it exists in HEAD. Paths are relative to dir.
+	HeadSizes(dir string, paths []string) ([]byte, error)
+	// IndexModes returns the output of `git ls-files --stage -z -- .` run in
+	// dir, which includes the files in its subdirectories
+	IndexModes(dir string) ([]byte, error)
+}
+
+// Git is the Repository which runs the git command
+type Git struct{}
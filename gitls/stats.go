@@ -0,0 +1,117 @@
+package gitls
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// fileSizes sets the human-readable size of each file. If recursive is true,
+// directories are shown with the total size of their contents, otherwise
+// they're shown as "-"
+func fileSizes(files []*Entry, recursive bool) {
+	for _, file := range files {
+		if file.isDir {
+			file.size = "-"
+			if recursive {
+				file.size = humanSize(dirSize(file.FullPath()))
+			}
+			continue
+		}
+		if stat, err := os.Stat(file.FullPath()); err == nil {
+			file.size = humanSize(stat.Size())
+		}
+	}
+}
+
+// dirSize returns the total size of the files within a directory. Symlinks
+// are not followed, and errors are ignored
+func dirSize(dir string) int64 {
+	var total int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// lineCounts sets the number of lines in each regular file, or "-" if the
+// file is binary. Other files, like symlinks and named pipes, aren't opened,
+// since reading a pipe could block forever.
+func lineCounts(files []*Entry) {
+	for _, file := range files {
+		if info, err := os.Lstat(file.FullPath()); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(file.FullPath())
+		if err != nil {
+			continue
+		}
+		if n, ok := countLines(f); ok {
+			file.lines = strconv.Itoa(n)
+		} else {
+			file.lines = "-"
+		}
+		f.Close()
+	}
+}
+
+// binarySniffLen is how many bytes git looks at to determine whether a file
+// is binary
+const binarySniffLen = 8000
+
+// countLines counts the lines in r, including a final line without a trailing
+// newline. ok is false if the contents are binary, which like git we detect
+// by looking for a NUL byte at the start of the contents.
+func countLines(r io.Reader) (n int, ok bool) {
+	buf := make([]byte, 32*1024)
+	read := 0
+	last := byte('\n')
+	for {
+		c, err := r.Read(buf)
+		if c > 0 {
+			if read < binarySniffLen && bytes.IndexByte(buf[:min(c, binarySniffLen-read)], 0) >= 0 {
+				return 0, false
+			}
+			read += c
+			n += bytes.Count(buf[:c], []byte{'\n'})
+			last = buf[c-1]
+		}
+		if err != nil {
+			break
+		}
+	}
+	if last != '\n' {
+		n++
+	}
+	return n, true
+}
+
+// humanSize formats a number of bytes in a human-readable way, like `ls -h`
+func humanSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 && n > -1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	i := -1
+	for (f >= 1024 || f <= -1024) && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if f < 10 && f > -10 {
+		return fmt.Sprintf("%.1f%c", f, units[i])
+	}
+	return fmt.Sprintf("%.0f%c", f, units[i])
+}
@@ -1,516 +1,494 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"slices"
-	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
-)
-
-const VERSION = "3.2.0"
-
-type Diff struct {
-	plus  int
-	minus int
-}
 
-type File struct {
-	entry        os.DirEntry
-	status       string
-	diffSum      *Diff
-	diffStat     string
-	author       string
-	authorEmail  string
-	hash         string
-	lastModified string
-	message      string
-	isDir        bool
-	isExe        bool
-}
-
-const (
-	BLUE   = "\x1b[34m"
-	GREEN  = "\x1b[32m"
-	RED    = "\x1b[31m"
-	RESET  = "\x1b[0m"
-	YELLOW = "\x1b[33m"
+	"github.com/llimllib/git-ls/gitls"
 )
 
-func must[T any](a T, e error) T {
-	if e != nil {
-		panic(e)
-	}
-	return a
-}
+const VERSION = "3.2.0"
 
-func usage() {
+// usage prints the man page, with the options generated from flags
+func usage(flags []flag) {
 	fmt.Printf(`GIT-LS(1)
 
 NAME
     git-ls - show the current directory annotated with links and git info
 
 SYNOPSIS
-    git ls [<dir>]
+    git ls [<options>] [--] [<file or dir>...]
 
 DESCRIPTION
-    Displays the files in the current directory, their current git status, a short diffstat, their last modified date, the author and a portion of the last commit message for that file.
+    Displays the files in the current directory, or in each given directory, their current git status, a short diffstat, their last modified date, the author and a portion of the last commit message for that file. Files given as arguments are listed together, followed by the contents of each given directory.
 
-    All files are hyperlinked with OSC8 hyperlinks, so you should be able to open them by clicking on them in a properly-configured terminal. The author names are hyperlinked to github if the repository has a github remote, as are commit messages.
+    Binary files don't have a line-based diffstat, so changes to them are shown as "bin" followed by the difference between the size of the file in HEAD and in the working tree.
 
-OPTIONS
-    --version
-        Print the version number and exit
+    Files tracked by git LFS are marked with "lfs" followed by the size of the object if its contents are present locally, or with "ptr" if only the LFS pointer file is available.
 
-    --help
-        Print this message and exit
+    Hidden files, whose names start with a ".", are not shown unless they have been changed according to git status. Set the git config option git-ls.showHidden to true to show them by default.
 
-    --diffWidth=n
-        Print the diffStat graph with the given width. Default is 4
+    All files are hyperlinked with OSC8 hyperlinks, so you should be able to open them by clicking on them in a properly-configured terminal. If the repository has a remote on a forge, either github.com or one configured as described below, the author names are hyperlinked to their commits on the forge, and commit messages to their commits.
 
-%s
-`, link("https://github.com/llimllib/git-ls", "https://github.com/llimllib/git-ls"))
-}
+    References in commit messages to pull requests and issues, written as #<n> or GH-<n>, <owner>/<repo>#<n> for another repository, or !<n> for a merge request, link to the pull request on github.com. Set git-ls.linkIssues to true to link #<n> and GH-<n> to the issue instead. On other forges, where merge requests are written as !<n>, #<n> links to the issue.
 
-func main() {
-	argv := os.Args[1:]
-	diffWidth := 4
-	for len(argv) > 0 {
-		if argv[0] == "--version" {
-			fmt.Printf("%s\n", VERSION)
-			os.Exit(0)
-		}
-		if argv[0] == "--help" || argv[0] == "-h" {
-			usage()
-			os.Exit(0)
-		}
-		if strings.HasPrefix(argv[0], "--diffWidth") {
-			if len(argv) == 1 {
-				if strings.Contains(argv[0], "=") {
-					parts := strings.SplitN(argv[0], "=", 2)
-					diffWidth = must(strconv.Atoi(parts[1]))
-				} else {
-					log.Fatalf("--diffWidth requires an argument")
-				}
-				argv = argv[1:]
-			} else {
-				diffWidth = must(strconv.Atoi(argv[1]))
-				argv = argv[2:]
-			}
-		}
-	}
+    Other references in commit messages, like the keys of Jira issues, can be linked by setting a regular expression to match them with git-ls.reference.<name>.pattern, and the URL to link them to with git-ls.reference.<name>.url. In the URL, {0} is replaced with the whole reference, {1} and up with the groups in the pattern, and {host}, {owner} and {repo} with the parts of the forge's URL. For example:
 
-	var dir string
-	if len(argv) > 1 {
-		dir = argv[1]
+        git config git-ls.reference.jira.pattern '\b[A-Z]+-[0-9]+\b'
+        git config git-ls.reference.jira.url 'https://jira.example.com/browse/{0}'
 
-		if err := os.Chdir(dir); err != nil {
-			log.Fatalf("Failed to change directory to %s: %v", dir, err)
-		}
-	} else {
-		dir = "."
-	}
+    File names link to their file:// URL by default. To open them in an editor instead, set git-ls.fileLink to vscode, idea, zed or sublime, or to editor to use the one in $VISUAL or $EDITOR. Set it to forge to link to their pages on the forge, on the current branch. git-ls.fileLink can also be a template for the URL, in which {path} is replaced with the absolute path of the file, {relpath} with its path within the repository, both escaped for use in a URL, and {hostname} with the name of this host. Directories link the same way as files, unless git-ls.dirLink is set to another template, such as a URL your terminal handles by running git ls in the directory.
 
-	osfiles, err := os.ReadDir(dir)
-	if err != nil {
-		log.Fatalf("Failed to read directory %s: %v", dir, err)
-	}
-
-	var files []*File
-	for _, file := range osfiles {
-		stat, _ := os.Stat(file.Name())
-		files = append(files, &File{
-			entry: file,
-			isDir: file.IsDir(),
-			isExe: !file.IsDir() && stat.Mode()&0111 != 0,
-		})
-	}
+    To link to another forge, such as GitHub Enterprise or a self-hosted GitLab, set URL templates for its host with the git config options git-ls.forge.<host>.commit, .author, .issue, .pr, .file and .dir. In the templates, {host}, {owner} and {repo} are replaced with the parts of the remote's URL, {hash} with the commit hash, {email} with the author's email, {number} with the issue or pull request number, and {rev} and {path} with the revision and the path of a file. For example:
 
-	root := gitRoot()
-	curdir := must(filepath.Rel(root, must(filepath.Abs("."))))
-	fileStatus(gitStatus(), files, curdir)
-	parseGitLog(files, gitLog)
-	parseDiffStat(gitDiffStat(), files)
+        git config git-ls.forge.git.corp.example.commit 'https://{host}/{owner}/{repo}/commit/{hash}'
 
-	// generate a diffStat graph for every file
-	for _, file := range files {
-		file.diffStat = makeDiffGraph(file, diffWidth)
-	}
-
-	maxWidth := columns(os.Stdout.Fd())
-	fmt.Printf("On branch %s%s%s\n\n", RED, gitCurrentBranch(), RESET)
-	show(os.Stdout, maxWidth, files, isGithub(gitRemotes()), must(filepath.Abs(dir)))
+OPTIONS
+%s
+%s
+`, flagsHelp(flags), gitls.Link("https://github.com/llimllib/git-ls", "https://github.com/llimllib/git-ls"))
 }
 
-func link(url string, name string) string {
-	// hyperlink format: \e]8;;<url>\e\<link text>\e]8;;\e\
-	return fmt.Sprintf("\x1b]8;;%s\x1b\\%s\x1b]8;;\x1b\\", url, name)
+// options holds the settings given on the command line
+type options struct {
+	gitls.Options
+	// remote is the remote whose forge the listing links to, and links is
+	// the template for links to files and directories
+	remote string
+	links  string
+	// wrap puts the rest of commit messages which don't fit on a second
+	// line, and icons shows an icon for the type of each entry
+	wrap  bool
+	icons bool
+	// interactive opens the browser instead of printing the listing, and
+	// watch redraws the listing whenever something changes
+	interactive bool
+	watch       bool
+	// version and help are set by flags which print something and exit
+	version bool
+	help    bool
 }
 
-func linkify(commitMsg string, github string, hash string) string {
-	issueRe := regexp.MustCompile(`#(\d+)`)
-	issueIx := issueRe.FindStringIndex(commitMsg)
-	out := make([]string, 0, 16)
-	for issueIx != nil {
-		commitUrl := fmt.Sprintf("%s/commit/%s", github, hash)
-		out = append(out, link(commitUrl, commitMsg[:issueIx[0]]))
-
-		issueUrl := fmt.Sprintf("%s/pull/%s", github, commitMsg[issueIx[0]+1:issueIx[1]])
-		issueText := fmt.Sprintf("%s%s%s", BLUE, commitMsg[issueIx[0]:issueIx[1]], RESET)
-		out = append(out, link(issueUrl, issueText))
-
-		commitMsg = commitMsg[issueIx[1]:]
-		issueIx = issueRe.FindStringIndex(commitMsg)
+// cliFlags returns the command line options, which set their values in opts
+func cliFlags(opts *options) []flag {
+	return []flag{
+		{
+			long: "version",
+			help: "Print the version number and exit",
+			set:  boolFlag(&opts.version),
+		},
+		{
+			short: 'h',
+			long:  "help",
+			help:  "Print this message and exit",
+			set:   boolFlag(&opts.help),
+		},
+		{
+			short: 'a',
+			long:  "all",
+			help:  `Show hidden files, including the "." and ".." entries`,
+			set: func(string) error {
+				opts.All = true
+				opts.Hidden = true
+				return nil
+			},
+		},
+		{
+			short: 'A',
+			long:  "almost-all",
+			help:  `Show hidden files, but not the "." and ".." entries`,
+			set:   boolFlag(&opts.Hidden),
+		},
+		{
+			short: 'w',
+			long:  "diffWidth",
+			arg:   "n",
+			help:  "Print the diffStat graph with the given width. Default is 4",
+			set:   intFlag(&opts.DiffWidth, 1),
+		},
+		{
+			short: 'i',
+			long:  "interactive",
+			help:  "Open a full-screen browser of the directory. Use the arrow keys to move, enter to open a directory or edit a file with $EDITOR, s to stage and u to unstage the selected file, d to view its diff, l to view its log and q to quit",
+			set:   boolFlag(&opts.interactive),
+		},
+		{
+			long: "watch",
+			help: "Keep running, and redraw the listing whenever a listed file, the index or HEAD changes",
+			set:  boolFlag(&opts.watch),
+		},
+		{
+			short: 'j',
+			long:  "jobs",
+			arg:   "n",
+			help:  "Run up to n git commands at once to look up the history of each file. Defaults to the number of CPUs",
+			set:   intFlag(&opts.Jobs, 1),
+		},
+		{
+			long: "no-cache",
+			help: "Don't use the cache of each file's last commit, which is stored in the git directory and updated as HEAD moves",
+			set:  boolFlag(&opts.NoCache),
+		},
+		{
+			long: "timeout",
+			arg:  "duration",
+			help: `Stop looking up the history of files after the given duration, like "500ms" or "2s", and show "…" for the files that didn't finish`,
+			set:  durationFlag(&opts.Timeout),
+		},
+		{
+			long: "query-timeout",
+			arg:  "duration",
+			help: `Stop looking up the history of any single file after the given duration, and show "…" for it instead`,
+			set:  durationFlag(&opts.QueryTimeout),
+		},
+		{
+			long: "remote",
+			arg:  "name",
+			help: "Link to the forge of the given remote. Defaults to the git-ls.remote config option, or else the current branch's upstream remote, upstream or origin",
+			set:  stringFlag(&opts.remote),
+		},
+		{
+			long: "links",
+			arg:  "template",
+			help: `Link file and directory names with the given template, or the built-in template with that name: file, vscode, idea, zed, sublime, editor, or forge to link to their pages on the forge`,
+			set:  stringFlag(&opts.links),
+		},
+		{
+			long: "blame",
+			help: "For each regular file, show the author who owns the largest share of its current lines according to git blame, and the percentage of lines they own",
+			set:  boolFlag(&opts.Blame),
+		},
+		{
+			short: 's',
+			long:  "size",
+			help:  `Show the size of each file. Directories are shown as "-" unless --totalSize is given`,
+			set:   boolFlag(&opts.Size),
+		},
+		{
+			long: "totalSize",
+			help: "Show the size of each file, and the total size of the contents of each directory, computed recursively",
+			set: func(string) error {
+				opts.Size = true
+				opts.TotalSize = true
+				return nil
+			},
+		},
+		{
+			long: "lines",
+			help: `Show the number of lines in each text file. Binary files are shown as "-"`,
+			set:  boolFlag(&opts.Lines),
+		},
+		{
+			short: 'l',
+			long:  "long",
+			help:  `Start each line with the mode, owner, group, size and modification time of the file, like ls -l. Files whose executable bit differs from the mode git records for them have their mode shown in red, followed by "+x" if the file is executable but git records it as not, or "-x" for the reverse`,
+			set:   boolFlag(&opts.Long),
+		},
+		{
+			long: "wrap",
+			help: "Wrap commit messages which don't fit in the terminal onto a second line, instead of cutting them short",
+			set:  boolFlag(&opts.wrap),
+		},
+		{
+			long: "icons",
+			help: "Show an icon for the type of each file before its name. The icons need a Nerd Font (https://www.nerdfonts.com)",
+			set:  boolFlag(&opts.icons),
+		},
 	}
-	out = append(out, link(fmt.Sprintf("%s/commit/%s", github, hash), commitMsg))
-
-	return strings.Join(out, "")
 }
 
-const ansiMarker = '\x1b'
-
-// width returns the printable width of a string in a terminal, by ignoring
-// ansi sequences. This version assumes all characters have a width of 1, which
-// is not true in general but is true in this program. modified from:
-// https://github.com/muesli/ansi/blob/276c6243b/buffer.go#L21
-func width(s string) int {
-	var n int
-	var ansi bool
-
-	for _, c := range s {
-		if c == ansiMarker {
-			ansi = true
-		} else if ansi {
-			// @, A-Z, a-z terminate the escape
-			if (c >= 0x40 && c <= 0x5a) || (c >= 0x61 && c <= 0x7a) {
-				ansi = false
-			}
-		} else {
-			// Just assuming single-width characters is good enough™ in this
-			// case
-			n += 1
-		}
+func main() {
+	opts := options{Options: gitls.Options{
+		DiffWidth:  4,
+		Repository: gitls.Git{},
+	}}
+	flags := cliFlags(&opts)
+	operands, err := parseArgs(flags, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "git-ls: %v\nTry 'git ls --help' for more information.\n", err)
+		os.Exit(2)
 	}
-
-	return n
-}
-
-type windowSize struct {
-	rows uint16
-	cols uint16
-}
-
-// from https://github.com/epam/hubctl/blob/6f86e6663/cmd/hub/lifecycle/terminal.go#L59
-func columns(fd uintptr) int {
-	var sz windowSize
-	_, _, _ = syscall.Syscall(syscall.SYS_IOCTL,
-		fd, uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&sz)))
-	return int(sz.cols)
-}
-
-// Pulled straight from git:
-// https://github.com/git/git/blob/d4cc1ec3/diff.c#L2862-L2874
-func scale_linear(n int, width int, max_change int) int {
-	if n == 0 {
-		return 0
+	if opts.version {
+		fmt.Printf("%s\n", VERSION)
+		os.Exit(0)
+	}
+	if opts.help {
+		usage(flags)
+		os.Exit(0)
+	}
+	if len(operands) == 0 {
+		operands = []string{"."}
 	}
-	/*
-	 * make sure that at least one '-' or '+' is printed if
-	 * there is any change to this path. The easiest way is to
-	 * scale linearly as if the allotted width is one column shorter
-	 * than it is, and then add 1 to the result.
-	 */
-	return 1 + (n * (width - 1) / max_change)
-}
 
-// makeDiffGraph turns the total diff for a file/directory into a diff graph
-// string.
-func makeDiffGraph(file *File, width int) string {
-	if file.diffSum == nil {
-		return ""
+	exitCode := 0
+	fileOperands, dirOperands, ok := splitOperands(os.Stderr, operands)
+	if !ok {
+		exitCode = 2
 	}
-	plus := file.diffSum.plus
-	minus := file.diffSum.minus
-	if plus+minus <= width {
-		return fmt.Sprintf("%s%s%s%s%s",
-			GREEN,
-			strings.Repeat("+", plus),
-			RED,
-			strings.Repeat("-", plus),
-			RESET)
+	if len(fileOperands) == 0 && len(dirOperands) == 0 {
+		os.Exit(exitCode)
 	}
-	return fmt.Sprintf("%s%s%s%s%s",
-		GREEN,
-		strings.Repeat("+", scale_linear(plus, width, plus+minus)),
-		RED,
-		strings.Repeat("-", scale_linear(minus, width, plus+minus)),
-		RESET)
-}
 
-func show(out io.Writer, maxWidth int, files []*File, githubUrl string, dir string) {
-	maxStatus := 0
-	maxDiffStat := 0
-	maxNameLen := 0
-	for _, file := range files {
-		if len(file.status) > maxStatus {
-			maxStatus = len(file.status)
+	if opts.interactive {
+		if opts.watch {
+			fmt.Fprintf(os.Stderr, "git-ls: --interactive can't be combined with --watch\n")
+			os.Exit(2)
 		}
-		if width(file.diffStat) > maxDiffStat {
-			maxDiffStat = width(file.diffStat)
+		if len(fileOperands) > 0 || len(dirOperands) > 1 {
+			fmt.Fprintf(os.Stderr, "git-ls: --interactive accepts a single directory\n")
+			os.Exit(2)
 		}
-		if len(file.entry.Name()) > maxNameLen {
-			maxNameLen = len(file.entry.Name())
+		if err := browse(dirOperands[0], opts); err != nil {
+			log.Fatalf("%v", err)
 		}
+		return
 	}
 
-	for _, file := range files {
-		// lineWidth tracks the width of the current line
-		lineWidth := 0
+	if opts.watch {
+		if err := watch(fileOperands, dirOperands, opts); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
 
-		// print the file's git status. If there are no modified files, skip entirely
-		if maxStatus > 0 {
-			fmt.Fprintf(out, fmt.Sprintf("%%%ds ", maxStatus), file.status)
-			lineWidth += maxStatus + 1
+	if err := render(os.Stdout, columns(os.Stdout.Fd()), fileOperands, dirOperands, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "git-ls: %v\n", err)
+		exitCode = 2
+	}
+	os.Exit(exitCode)
+}
 
-			// print the diffstat summary for the file
-			fmt.Fprintf(out, "%s", file.diffStat)
-			for i := 0; i < maxDiffStat-width(file.diffStat)+1; i++ {
-				fmt.Fprintf(out, " ")
+// splitOperands splits the operands into files and directories, since like
+// ls, we list all the file operands together first, then each directory. It
+// reports the operands which can't be accessed to stderr, and returns false
+// if there were any.
+func splitOperands(stderr io.Writer, operands []string) (fileOperands, dirOperands []string, ok bool) {
+	ok = true
+	for _, operand := range operands {
+		stat, err := os.Stat(operand)
+		if err != nil {
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
 			}
-			lineWidth += 5
-		}
-
-		if file.isDir {
-			fmt.Fprintf(out, "%s", BLUE)
-		}
-		if file.isExe {
-			fmt.Fprintf(out, "%s", GREEN)
-		}
-		// link the file name to the file's location
-		fileUrl := fmt.Sprintf("file://%s%s", must(os.Hostname()), filepath.Join(dir, file.entry.Name()))
-		fmt.Fprintf(out, "%s", link(fileUrl, file.entry.Name()))
-		// pad spaces to the right up to maxNameLen
-		for i := 0; i < maxNameLen-len(file.entry.Name()); i++ {
-			fmt.Fprintf(out, " ")
+			fmt.Fprintf(stderr, "git-ls: cannot access %s: %v\n", operand, err)
+			ok = false
+			continue
 		}
-		if file.isDir || file.isExe {
-			fmt.Fprintf(out, "%s", RESET)
+		if stat.IsDir() {
+			dirOperands = append(dirOperands, operand)
+		} else {
+			fileOperands = append(fileOperands, operand)
 		}
-		lineWidth += maxNameLen
+	}
+	return fileOperands, dirOperands, ok
+}
 
-		// write the last modified date
-		fmt.Fprintf(out, " %s", file.lastModified)
-		lineWidth += len(file.lastModified) + 1
+// section is a part of the listing: either the file operands which are in
+// one repository, or a directory operand
+type section struct {
+	// root is the root of the repository the section is in
+	root string
+	// dir is the directory whose branch and links the section uses
+	dir string
+	// files are the file operands, or nil for a directory operand
+	files []string
+}
 
-		if lineWidth >= maxWidth {
-			fmt.Println("")
-			continue
+// listingSections returns the sections of the listing: the file operands
+// grouped by the repository they're in, then each directory operand
+func listingSections(repo gitls.Repository, fileOperands, dirOperands []string) ([]section, error) {
+	roots := make(map[string]string)
+	root := func(dir string) (string, error) {
+		if root, ok := roots[dir]; ok {
+			return root, nil
 		}
-		authorWidth := min(len(file.author), maxWidth-1-lineWidth)
-		lineWidth += authorWidth + 1
-		if len(githubUrl) > 0 {
-			// if this is a github repo, link the author name to their commits
-			// page on github. It would be cool to hyperlink the author to
-			// a git command, but I'm not sure how to give a URL for the command
-			// `git log --author=Janet`
-			authorLink := fmt.Sprintf("%s/commits?author=%s", githubUrl, file.authorEmail)
-			fmt.Fprintf(out, " %s%s%s", YELLOW, link(authorLink, file.author[:authorWidth]), RESET)
-		} else {
-			fmt.Fprintf(out, " %s%s%s", YELLOW, file.author[:authorWidth], RESET)
+		root, err := repo.Root(dir)
+		if err != nil {
+			return "", err
 		}
+		roots[dir] = root
+		return root, nil
+	}
 
-		// If this is a github repo, look for #<issue> links and linkify them.
-		// Otherwise just output the first 80 chars of the commit msg. Would it
-		// be better to use the full width of the terminal if available here,
-		// or just keep it shortish?
-		if lineWidth >= maxWidth {
-			fmt.Println("")
+	var sections []section
+	fileSections := make(map[string]int)
+	for _, file := range fileOperands {
+		dir := filepath.Dir(file)
+		root, err := root(dir)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := fileSections[root]; ok {
+			sections[i].files = append(sections[i].files, file)
 			continue
 		}
-		messageWidth := min(len(file.message), maxWidth-1-lineWidth)
-		if len(githubUrl) > 0 {
-			fmt.Fprintf(out, " %s\n", linkify(file.message[:messageWidth], githubUrl, file.hash))
-		} else {
-			fmt.Fprintf(out, " %s\n", file.message[:messageWidth])
+		fileSections[root] = len(sections)
+		sections = append(sections, section{root: root, dir: dir, files: []string{file}})
+	}
+	for _, dir := range dirOperands {
+		root, err := root(dir)
+		if err != nil {
+			return nil, err
 		}
+		sections = append(sections, section{root: root, dir: dir})
 	}
+	return sections, nil
 }
 
-func gitRemotes() []byte {
-	cmd := exec.Command("git", "remote", "-v")
-	out, err := cmd.Output()
+// render lists the file operands together, then the contents of each
+// directory operand under a header if there's more than one operand. The
+// current branch is shown at the top, unless the operands are in more than
+// one repository, in which case it's shown for each section.
+func render(out io.Writer, maxWidth int, fileOperands, dirOperands []string, opts options) error {
+	repo := opts.Repository
+	sections, err := listingSections(repo, fileOperands, dirOperands)
 	if err != nil {
-		log.Fatalf("Failed to get git status: %v", err)
+		return err
 	}
-	return out
-}
 
-func isGithub(out []byte) string {
-	githubRe := regexp.MustCompile(`github.com[:/]([\w-_]+)/([\w-_]+)`)
-	matches := githubRe.FindStringSubmatch(string(out))
-	if len(matches) == 3 {
-		return fmt.Sprintf("https://github.com/%s/%s", matches[1], matches[2])
+	// the branches and remotes don't depend on the listing, so start looking
+	// them up while the listing is generated
+	branches := make(map[string]func() (string, error))
+	links := make(map[string]func() (gitls.RenderOptions, error))
+	for _, s := range sections {
+		if _, ok := branches[s.root]; !ok {
+			branches[s.root] = sync.OnceValues(func() (string, error) { return repo.Branch(s.dir) })
+			go branches[s.root]()
+		}
+		if _, ok := links[s.dir]; !ok {
+			links[s.dir] = sync.OnceValues(func() (gitls.RenderOptions, error) {
+				renderOpts, err := gitls.LoadRenderOptions(repo, s.dir, opts.remote, opts.links)
+				renderOpts.Width = maxWidth
+				renderOpts.Wrap = opts.wrap
+				renderOpts.Icons = opts.icons
+				return renderOpts, err
+			})
+			go links[s.dir]()
+		}
 	}
-	return ""
-}
-
-func gitCurrentBranch() string {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	out, err := cmd.Output()
-	if err != nil {
-		log.Fatalf("Failed to get git status: %v", err)
+	printBranch := func(root string) error {
+		name, err := branches[root]()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "On branch %s%s%s\n\n", gitls.RED, name, gitls.RESET)
+		return nil
 	}
-	return strings.TrimSpace(string(out))
-}
 
-// gitRoot returns the root directory of the git repository
-func gitRoot() string {
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	out, err := cmd.Output()
-	if err != nil {
-		log.Fatalf("Failed to get git status: %v", err)
+	// the time limit covers the whole listing, so apply it here rather than
+	// to each call
+	ctx, cancel := context.WithCancel(context.Background())
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), opts.Timeout)
 	}
-	return strings.TrimSpace(string(out))
-}
+	defer cancel()
 
-// gitStatus accepts a dir and a slice of files, and adds the git status to
-// each file in place
-func gitStatus() []byte {
-	cmd := exec.Command("git", "status", "--porcelain", "--ignored")
-	out, err := cmd.Output()
-	if err != nil {
-		log.Fatalf("Failed to get git status: %v", err)
+	type listing struct {
+		entries []gitls.Entry
+		err     error
 	}
-	return out
-}
-
-func fileStatus(status []byte, files []*File, curdir string) {
-	gitStatusMap := make(map[string][]string)
-	lines := strings.Split(string(status), "\n")
-
-	for _, line := range lines {
-		if len(line) >= 3 {
-			status := line[:2]
-			// TODO: reject filenames that aren't in the current directory. Can
-			// we just ignore ".." entries? Right now, if you're in /subdir,
-			// and there's changes in /otherdir/whatever , this will create
-			// gitStatusMap entries of "..", which doesn't seem to mess stuff
-			// up but isn't ideal either
-			fileName := first(must(filepath.Rel(curdir, line[3:])))
-			if status == "!!" {
-				status = "I"
+	list := func(s section) ([]gitls.Entry, error) {
+		l := spin(func() listing {
+			var entries []gitls.Entry
+			var err error
+			if s.files != nil {
+				entries, err = gitls.ListFilesContext(ctx, s.files, opts.listOptions(s.dir))
+			} else {
+				entries, err = gitls.ListContext(ctx, s.dir, opts.listOptions(s.dir))
 			}
-			gitStatusMap[fileName] = append(gitStatusMap[fileName], status)
-		}
+			return listing{entries, err}
+		})
+		return l.entries, l.err
 	}
 
-	for _, file := range files {
-		if fileStatus, ok := gitStatusMap[file.entry.Name()]; ok {
-			slices.Sort(fileStatus)
-			file.status = strings.Join(slices.Compact(fileStatus), ",")
+	oneRepository := len(branches) == 1
+	for i, s := range sections {
+		files, err := list(s)
+		if err != nil {
+			return err
 		}
-		if file.entry.Name() == ".git" {
-			file.status = "*"
+		if i == 0 && oneRepository {
+			if err := printBranch(s.root); err != nil {
+				return err
+			}
 		}
-	}
-}
-
-func gitLog(file *File) []byte {
-	cmd := exec.Command("git", "log", "-1", "--date=format:%Y-%m-%d",
-		"--pretty=format:%h%x00%ad%x00%aN%x00%aE%x00%s", "--", file.entry.Name())
-	out, err := cmd.Output()
-	if err != nil {
-		log.Fatalf("Failed to get git info for file %s: %v", file.entry.Name(), err)
-	}
-	return out
-}
-
-func parseGitLog(files []*File, gitLog func(file *File) []byte) {
-	for _, file := range files {
-		out := gitLog(file)
-
-		if len(out) == 0 {
-			continue
+		if i > 0 {
+			fmt.Fprintln(out)
 		}
-
-		parts := strings.SplitN(string(out), "\x00", 5)
-		if len(parts) != 5 {
-			log.Fatalf("unexpected output format: %#v", out)
+		if s.files == nil && len(fileOperands)+len(dirOperands) > 1 {
+			fmt.Fprintf(out, "%s:\n", s.dir)
 		}
-
-		file.hash = parts[0]
-		file.lastModified = parts[1]
-		file.author = parts[2]
-		file.authorEmail = parts[3]
-		file.message = parts[4]
+		if !oneRepository {
+			if err := printBranch(s.root); err != nil {
+				return err
+			}
+		}
+		renderOpts, err := links[s.dir]()
+		if err != nil {
+			return err
+		}
+		gitls.Render(out, files, renderOpts)
 	}
+	return nil
 }
 
-// first returns the first part of a filepath. Given "some/file/path", it will
-// return "some". Modified from golang's built-in Split function:
-// https://github.com/golang/go/blob/c5698e315/src/internal/filepathlite/path.go#L204-L212
-func first(path string) string {
-	i := 0
-	for i < len(path) && !os.IsPathSeparator(path[i]) {
-		i++
-	}
-	return path[:i]
+type windowSize struct {
+	rows uint16
+	cols uint16
 }
 
-// diff returns an integer for +/-, or a literal '-' for a binary file. Return
-// 0 if the file was binary; we'll just ignore it for diffStat purposes. Is
-// there anything better to do with them here?
-func diffInt(s string) int {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return 0
-	}
-	return i
+// from https://github.com/epam/hubctl/blob/6f86e6663/cmd/hub/lifecycle/terminal.go#L59
+func terminalSize(fd uintptr) windowSize {
+	var sz windowSize
+	_, _, _ = syscall.Syscall(syscall.SYS_IOCTL,
+		fd, uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&sz)))
+	return sz
 }
 
-func gitDiffStat() []byte {
-	cmd := exec.Command("git", "diff", "--numstat", "--relative", "HEAD")
-	output, err := cmd.Output()
-	if err != nil {
-		log.Fatalf("Diffstat error: %v", err)
-	}
-	return output
+// columns returns the width of the terminal, or 0 if fd isn't a terminal
+func columns(fd uintptr) int {
+	return int(terminalSize(fd).cols)
 }
 
-func parseDiffStat(diffStat []byte, files []*File) {
-	diffStats := make(map[string][]Diff)
-	lines := strings.Split(strings.TrimSpace(string(diffStat)), "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, "\t")
-		if len(parts) < 3 {
-			continue
-		}
+// rows returns the height of the terminal, or 0 if fd isn't a terminal
+func rows(fd uintptr) int {
+	return int(terminalSize(fd).rows)
+}
 
-		plus := diffInt(parts[0])
-		minus := diffInt(parts[1])
-		path := first(strings.TrimSpace(parts[2]))
-		diffStats[path] = append(diffStats[path], Diff{plus, minus})
-	}
+// gitCommand returns a command which runs git with the given arguments in dir
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return cmd
+}
 
-	for _, file := range files {
-		// if the file has any diffs, sum them up. This way we aggregate a
-		// directory's diffs
-		if stats, ok := diffStats[file.entry.Name()]; ok {
-			plus := 0
-			minus := 0
-			for _, stat := range stats {
-				plus += stat.plus
-				minus += stat.minus
-			}
+// listOptions returns the options for listing dir. Hidden files are shown if
+// -a or -A was given, or if git-ls.showHidden is set in dir's repository.
+func (o *options) listOptions(dir string) gitls.Options {
+	listOpts := o.Options
+	listOpts.Hidden = listOpts.Hidden || gitConfigBool(dir, "git-ls.showHidden")
+	return listOpts
+}
 
-			file.diffSum = &Diff{plus, minus}
-		}
+// gitConfigBool returns the value of a boolean git config option in the
+// repository containing dir, or false if it isn't set
+func gitConfigBool(dir string, key string) bool {
+	cmd := exec.Command("git", "-C", dir, "config", "--type=bool", "--get", key)
+	out, err := cmd.Output()
+	if err != nil {
+		return false
 	}
+	return strings.TrimSpace(string(out)) == "true"
 }
@@ -1,240 +1,202 @@
 package main
 
 import (
-	"fmt"
+	"bytes"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/llimllib/git-ls/gitls"
 )
 
-func TestIsGithub(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    []byte
-		expected string
-	}{
-		{
-			name:     "Valid GitHub remote",
-			input:    []byte("origin\tgit@github.com:username/repo.git (fetch)\norigin\tgit@github.com:username/repo.git (push)"),
-			expected: "https://github.com/username/repo",
-		},
-		{
-			name:     "Valid GitHub remote with HTTP",
-			input:    []byte("origin\thttps://github.com/username/repo.git (fetch)\norigin\thttps://github.com/username/repo.git (push)"),
-			expected: "https://github.com/username/repo",
-		},
-		{
-			name:     "Invalid remote",
-			input:    []byte("origin\tgit@example.com:username/repo.git (fetch)\norigin\tgit@example.com:username/repo.git (push)"),
-			expected: "",
-		},
-		{
-			name:     "Empty input",
-			input:    []byte{},
-			expected: "",
-		},
+// testRepos creates a repository for each of the given branch names, each
+// on that branch and containing f.txt and d/g.txt, and returns the directory
+// containing them
+func testRepos(t *testing.T, branches ...string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
 	}
+	dir := t.TempDir()
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Author")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Committer")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := isGithub(tt.input)
-			if result != tt.expected {
-				t.Errorf("isGithub(%s) = %q, expected %q", tt.input, result, tt.expected)
+	for _, branch := range branches {
+		repo := filepath.Join(dir, branch)
+		if err := os.MkdirAll(filepath.Join(repo, "d"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"f.txt", "d/g.txt"} {
+			if err := os.WriteFile(filepath.Join(repo, name), []byte(name+"\n"), 0o644); err != nil {
+				t.Fatal(err)
 			}
-		})
+		}
+		for _, args := range [][]string{
+			{"init", "-q", "-b", branch},
+			{"add", "-A"},
+			{"commit", "-q", "-m", "Initial commit"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = repo
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %v failed: %v\n%s", args, err, out)
+			}
+		}
 	}
+	return dir
 }
 
-type mockDirEntry struct {
-	name string
-}
+func TestSplitOperands(t *testing.T) {
+	dir := testRepos(t, "main")
+	file := filepath.Join(dir, "main", "f.txt")
+	subdir := filepath.Join(dir, "main", "d")
+	missing := filepath.Join(dir, "missing")
 
-func (m *mockDirEntry) Name() string {
-	return m.name
-}
+	var stderr bytes.Buffer
+	files, dirs, ok := splitOperands(&stderr, []string{subdir, file, missing, dir})
+	if !slices.Equal(files, []string{file}) {
+		t.Errorf("Expected the file operands %v, got %v", []string{file}, files)
+	}
+	if !slices.Equal(dirs, []string{subdir, dir}) {
+		t.Errorf("Expected the directory operands %v, got %v", []string{subdir, dir}, dirs)
+	}
+	if ok {
+		t.Errorf("Expected a missing operand to fail")
+	}
+	expected := "git-ls: cannot access " + missing + ": no such file or directory\n"
+	if stderr.String() != expected {
+		t.Errorf("Expected the error %q, got %q", expected, stderr.String())
+	}
 
-func (m *mockDirEntry) IsDir() bool {
-	return false
+	stderr.Reset()
+	if _, _, ok := splitOperands(&stderr, []string{file}); !ok || stderr.Len() > 0 {
+		t.Errorf("Expected an existing file to succeed, got %v and %q", ok, stderr.String())
+	}
 }
 
-func (m *mockDirEntry) Type() os.FileMode {
-	return 0
-}
+// escapeRe matches the CSI and OSC escape sequences of the listing
+var escapeRe = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]|\x1b\\][^\x1b]*\x1b\\\\")
 
-func (m *mockDirEntry) Info() (os.FileInfo, error) {
-	return nil, nil
+// outline returns the headers of a listing, and the name of each file in it
+func outline(listing string) []string {
+	var lines []string
+	for _, line := range strings.Split(escapeRe.ReplaceAllString(listing, ""), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && !strings.HasPrefix(line, "On branch ") {
+			line = fields[0]
+		}
+		lines = append(lines, line)
+	}
+	return lines
 }
 
-func TestFileStatus(t *testing.T) {
-	tests := []struct {
-		name     string
-		status   string
-		files    []*File
-		dir      string
-		expected []string
+func TestRender(t *testing.T) {
+	dir := testRepos(t, "one", "two")
+	path := func(name string) string { return filepath.Join(dir, name) }
+	opts := options{Options: gitls.Options{DiffWidth: 4, Repository: gitls.Git{}}}
+
+	testCases := []struct {
+		name         string
+		fileOperands []string
+		dirOperands  []string
+		expected     []string
 	}{
 		{
-			name:     "empty status and files",
-			status:   "",
-			files:    []*File{},
-			expected: []string{},
-			dir:      "",
-		},
-		{
-			name:   "single file with modified status",
-			status: " M file.go",
-			files: []*File{
-				{entry: &mockDirEntry{name: "file.go"}},
-			},
-			expected: []string{" M"},
-			dir:      "",
-		},
-		{
-			name:   "multiple files with different statuses",
-			status: "M  file1.go\nA  file2.go\n!! ignored.go",
-			files: []*File{
-				{entry: &mockDirEntry{name: "file1.go"}},
-				{entry: &mockDirEntry{name: "file2.go"}},
-				{entry: &mockDirEntry{name: "ignored.go"}},
-			},
-			expected: []string{"M ", "A ", "I"},
-			dir:      "",
-		},
-		{
-			name:   ".git directory status",
-			status: "M  file1.go",
-			files: []*File{
-				{entry: &mockDirEntry{name: "file1.go"}},
-				{entry: &mockDirEntry{name: ".git"}},
+			name:         "One repository",
+			fileOperands: []string{path("one/f.txt"), path("one/d/g.txt")},
+			dirOperands:  []string{path("one/d")},
+			expected: []string{
+				"On branch one",
+				"",
+				path("one/f.txt"),
+				path("one/d/g.txt"),
+				"",
+				path("one/d") + ":",
+				"g.txt",
+				"",
 			},
-			expected: []string{"M ", "*"},
-			dir:      "",
 		},
 		{
-			name:   "subdirectory",
-			status: "M  homedir/file2.go",
-			files: []*File{
-				{entry: &mockDirEntry{name: "file2.go"}},
+			name:         "File operands in two repositories",
+			fileOperands: []string{path("one/f.txt"), path("two/f.txt"), path("one/d/g.txt")},
+			dirOperands:  []string{path("two/d")},
+			expected: []string{
+				"On branch one",
+				"",
+				path("one/f.txt"),
+				path("one/d/g.txt"),
+				"",
+				"On branch two",
+				"",
+				path("two/f.txt"),
+				"",
+				path("two/d") + ":",
+				"On branch two",
+				"",
+				"g.txt",
+				"",
 			},
-			dir:      "homedir/",
-			expected: []string{"M "},
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			fileStatus([]byte(tt.status), tt.files, tt.dir)
-			for i, f := range tt.files {
-				if f.status != tt.expected[i] {
-					t.Errorf("expected %s for %s, got %s", tt.expected[i], f.entry.Name(), f.status)
-				}
-			}
-		})
-	}
-}
-
-func mockGitLog(file *File) []byte {
-	switch file.entry.Name() {
-	case "file1.go":
-		return []byte("hash1\x002023-03-01\x00John Doe\x00john@example.com\x00Initial commit")
-	case "file2.go":
-		return []byte("hash2\x002023-03-02\x00Jane Smith\x00jane@example.com\x00Add new feature")
-	case "file3.go":
-		return []byte("hash3\x002023-03-03\x00Bob Johnson\x00bob@example.com\x00Fix a bug parsing '|' pipes")
-	case "file4.go":
-		return []byte("invalid output format")
-	default:
-		return nil
-	}
-}
-
-func TestParseGitLog(t *testing.T) {
-	testCases := []struct {
-		name     string
-		files    []*File
-		expected [][]string
-	}{
 		{
-			name: "Valid git log output",
-			files: []*File{
-				{entry: &mockDirEntry{name: "file1.go"}},
-				{entry: &mockDirEntry{name: "file2.go"}},
-				{entry: &mockDirEntry{name: "file3.go"}},
-			},
-			expected: [][]string{
-				{"file1.go", "hash1", "2023-03-01", "John Doe", "john@example.com", "Initial commit"},
-				{"file2.go", "hash2", "2023-03-02", "Jane Smith", "jane@example.com", "Add new feature"},
-				{"file3.go", "hash3", "2023-03-03", "Bob Johnson", "bob@example.com", "Fix a bug parsing '|' pipes"},
+			name:        "Directory operands in two repositories",
+			dirOperands: []string{path("one"), path("two/d")},
+			expected: []string{
+				path("one") + ":",
+				"On branch one",
+				"",
+				"d",
+				"f.txt",
+				"",
+				path("two/d") + ":",
+				"On branch two",
+				"",
+				"g.txt",
+				"",
 			},
 		},
-		// Is it worth bothering to test log.Fatal scenarios?
-		// {
-		// 	name: "Invalid git log output",
-		// 	files: []*File{
-		// 		{entry: &mockDirEntry{name: "file4.go"}},
-		// 	},
-		// 	expected: [][]string{{"file4.go"}},
-		// },
 	}
-
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			parseGitLog(tc.files, mockGitLog)
-
-			for i, file := range tc.files {
-				expected := tc.expected[i]
-				if file.hash != expected[1] {
-					t.Errorf("Unexpected hash for file %s: got %s, want %s", file.entry.Name(), file.hash, expected[1])
-				}
-				if file.lastModified != expected[2] {
-					t.Errorf("Unexpected lastModified for file %s: got %s, want %s", file.entry.Name(), file.lastModified, expected[2])
-				}
-				if file.author != expected[3] {
-					t.Errorf("Unexpected author for file %s: got %s, want %s", file.entry.Name(), file.author, expected[3])
-				}
-				if file.authorEmail != expected[4] {
-					t.Errorf("Unexpected authorEmail for file %s: got %s, want %s", file.entry.Name(), file.authorEmail, expected[4])
-				}
-				if file.message != expected[5] {
-					t.Errorf("Unexpected message for file %s: got %s, want %s", file.entry.Name(), file.message, expected[5])
-				}
+			var out bytes.Buffer
+			if err := render(&out, 0, tc.fileOperands, tc.dirOperands, opts); err != nil {
+				t.Fatal(err)
+			}
+			if lines := outline(out.String()); !slices.Equal(lines, tc.expected) {
+				t.Errorf("Expected the listing\n%s\ngot\n%s", strings.Join(tc.expected, "\n"), strings.Join(lines, "\n"))
 			}
 		})
 	}
 }
 
-func TestLinkify(t *testing.T) {
+func TestListOptions(t *testing.T) {
+	dir := testRepos(t, "one", "two")
+	cmd := exec.Command("git", "config", "git-ls.showHidden", "true")
+	cmd.Dir = filepath.Join(dir, "one")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git config failed: %v\n%s", err, out)
+	}
+
 	testCases := []struct {
 		name     string
-		test     string
-		expected string
+		dir      string
+		hidden   bool
+		expected bool
 	}{
-		{
-			name:     "Basic test",
-			test:     "Some message",
-			expected: link("https://github.com/a/b/commit/123abc", "Some message"),
-		},
-		{
-			name: "One issue link",
-			test: "fixes issue (#17)",
-			expected: link("https://github.com/a/b/commit/123abc", "fixes issue (") +
-				link("https://github.com/a/b/pull/17", fmt.Sprintf("%s%s%s", BLUE, "#17", RESET)) +
-				link("https://github.com/a/b/commit/123abc", ")"),
-		},
-		{
-			name: "Two issue links",
-			test: "fixes issue (#17) closes (#99)",
-			expected: link("https://github.com/a/b/commit/123abc", "fixes issue (") +
-				link("https://github.com/a/b/pull/17", fmt.Sprintf("%s%s%s", BLUE, "#17", RESET)) +
-				link("https://github.com/a/b/commit/123abc", ") closes (") +
-				link("https://github.com/a/b/pull/99", fmt.Sprintf("%s%s%s", BLUE, "#99", RESET)) +
-				link("https://github.com/a/b/commit/123abc", ")"),
-		},
+		{"Set in the listed repository", "one/d", false, true},
+		{"Not set in the listed repository", "two", false, false},
+		{"Shown by the flags", "two", true, true},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			s := linkify(tc.test, "https://github.com/a/b", "123abc")
-			if s != tc.expected {
-				t.Errorf("Expected\n%#v !=\n%#v", tc.expected, s)
+			opts := options{Options: gitls.Options{Hidden: tc.hidden}}
+			if hidden := opts.listOptions(filepath.Join(dir, tc.dir)).Hidden; hidden != tc.expected {
+				t.Errorf("Expected Hidden to be %v, got %v", tc.expected, hidden)
 			}
 		})
 	}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// spinnerDelay is how long work has to run before the spinner appears, so
+// that it doesn't flicker for listings that are quick to generate
+const spinnerDelay = 250 * time.Millisecond
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spin calls fn and returns its result. If stderr is a terminal and fn takes
+// a while, it shows a spinner on stderr until fn returns, so that it's clear
+// that git-ls hasn't frozen.
+func spin[T any](fn func() T) T {
+	if !isTerminal(os.Stderr.Fd()) {
+		return fn()
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-done:
+			return
+		case <-time.After(spinnerDelay):
+		}
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(os.Stderr, "\r%s reading history", spinnerFrames[i%len(spinnerFrames)])
+			select {
+			case <-done:
+				// erase the spinner
+				fmt.Fprint(os.Stderr, "\r\x1b[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	result := fn()
+	close(done)
+	<-stopped
+	return result
+}
+
+// isTerminal returns true if fd is a terminal
+func isTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
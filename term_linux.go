@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// ioctl requests to get and set the terminal's attributes
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/llimllib/git-ls/gitls"
+)
+
+// keys that don't correspond to a single byte of input
+const (
+	keyUp = iota + 256
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEscape
+)
+
+const (
+	keyCtrlC     = 3
+	keyEnter     = '\r'
+	keyBackspace = 127
+)
+
+// browser is an interactive, full-screen view of a directory listing
+type browser struct {
+	dir  string
+	root string
+	opts options
+
+	branch   string
+	links    gitls.RenderOptions
+	files    []gitls.Entry
+	selected int
+	offset   int
+	// message is shown in the status line until the next key is pressed
+	message string
+
+	restore func()
+}
+
+// browse opens the interactive browser in dir, and returns when the user
+// quits
+func browse(dir string, opts options) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// the root of the repository has its symlinks resolved, so the directory
+	// has to as well for parent to know when it has reached the root
+	realDir, err := filepath.EvalSymlinks(absDir)
+	if err != nil {
+		return err
+	}
+
+	b := &browser{dir: realDir, opts: opts}
+	b.load("")
+	if err := b.start(); err != nil {
+		return err
+	}
+	defer b.stop()
+
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+
+	b.draw()
+	for {
+		k, ok := readKey()
+		if !ok {
+			// the read timed out; redraw if the terminal changed size
+			select {
+			case <-resize:
+				b.draw()
+			default:
+			}
+			continue
+		}
+
+		b.message = ""
+		switch k {
+		case 'q', keyCtrlC, keyEscape:
+			return nil
+		case keyUp, 'k':
+			b.move(-1)
+		case keyDown, 'j':
+			b.move(1)
+		case keyPageUp:
+			b.move(-b.pageSize())
+		case keyPageDown:
+			b.move(b.pageSize())
+		case keyHome, 'g':
+			b.move(-len(b.files))
+		case keyEnd, 'G':
+			b.move(len(b.files))
+		case keyEnter, keyRight:
+			b.open()
+		case keyBackspace, keyLeft:
+			b.parent()
+		case 'e':
+			b.edit()
+		case 's':
+			b.git("add", "--")
+		case 'u':
+			b.git("restore", "--staged", "--")
+		case 'd':
+			b.diff()
+		case 'l':
+			b.log()
+		case 'r':
+			b.load(b.selectedName())
+		}
+		b.draw()
+	}
+}
+
+// start puts the terminal into raw mode and switches to the alternate screen
+func (b *browser) start() error {
+	restore, err := rawMode(os.Stdin.Fd())
+	if err != nil {
+		return fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	b.restore = restore
+	// use the alternate screen, hide the cursor and disable line wrapping
+	fmt.Print("\x1b[?1049h\x1b[?25l\x1b[?7l")
+	return nil
+}
+
+// stop restores the terminal to the state it was in before start
+func (b *browser) stop() {
+	fmt.Print("\x1b[?7h\x1b[?25h\x1b[?1049l")
+	b.restore()
+}
+
+// load lists the browser's directory, and selects the file named selected if
+// it's present
+func (b *browser) load(selected string) {
+	var err error
+	if b.root, err = b.opts.Repository.Root(b.dir); err != nil {
+		b.message = err.Error()
+	}
+	if b.branch, err = b.opts.Repository.Branch(b.dir); err != nil {
+		b.message = err.Error()
+	}
+	if b.links, err = gitls.LoadRenderOptions(b.opts.Repository, b.dir, b.opts.remote, b.opts.links); err != nil {
+		b.message = err.Error()
+	}
+	if b.files, err = gitls.List(b.dir, b.opts.listOptions(b.dir)); err != nil {
+		b.message = err.Error()
+	}
+	b.selected = 0
+	for i, file := range b.files {
+		if file.Name() == selected {
+			b.selected = i
+		}
+	}
+}
+
+func (b *browser) selectedFile() *gitls.Entry {
+	if b.selected < len(b.files) {
+		return &b.files[b.selected]
+	}
+	return nil
+}
+
+func (b *browser) selectedName() string {
+	if file := b.selectedFile(); file != nil {
+		return file.Name()
+	}
+	return ""
+}
+
+// pageSize returns the number of files that fit on the screen
+func (b *browser) pageSize() int {
+	// leave room for the header and the status line
+	return max(rows(os.Stdout.Fd())-3, 1)
+}
+
+func (b *browser) move(n int) {
+	b.selected = max(0, min(b.selected+n, len(b.files)-1))
+}
+
+// open descends into the selected directory, or edits the selected file
+func (b *browser) open() {
+	file := b.selectedFile()
+	if file == nil {
+		return
+	}
+	if !file.IsDir() {
+		b.edit()
+		return
+	}
+	switch {
+	case file.Name() == "..":
+		b.parent()
+	case file.Status() == "*":
+		b.message = "can't browse the .git directory"
+	default:
+		b.dir = file.FullPath()
+		if dir, err := filepath.EvalSymlinks(b.dir); err == nil {
+			b.dir = dir
+		}
+		b.load("")
+	}
+}
+
+// parent moves up to the parent directory, without leaving the repository
+func (b *browser) parent() {
+	if b.dir == b.root {
+		b.message = "already at the root of the repository"
+		return
+	}
+	name := filepath.Base(b.dir)
+	b.dir = filepath.Dir(b.dir)
+	b.load(name)
+}
+
+// edit opens the selected file in the user's editor
+func (b *browser) edit() {
+	file := b.selectedFile()
+	if file == nil || file.IsDir() {
+		return
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// run the editor with the shell, so that editors with arguments like
+	// "code --wait" work
+	b.interactive(exec.Command("sh", "-c", editor+` "$1"`, "sh", file.FullPath()))
+	b.load(file.Name())
+}
+
+// diff shows the changes to the selected file since HEAD. Untracked files
+// are diffed against an empty file.
+func (b *browser) diff() {
+	file := b.selectedFile()
+	if file == nil {
+		return
+	}
+	if file.Status() == "??" && !file.IsDir() {
+		b.interactive(pager(gitCommand(b.dir, "diff", "--no-index", "--", os.DevNull, file.Name())))
+	} else {
+		b.interactive(pager(gitCommand(b.dir, "diff", "HEAD", "--", file.Name())))
+	}
+}
+
+// log shows the history of the selected file
+func (b *browser) log() {
+	if file := b.selectedFile(); file != nil {
+		b.interactive(pager(gitCommand(b.dir, "log", "--", file.Name())))
+	}
+}
+
+// pager configures a git command which uses a pager so that the pager
+// doesn't exit immediately if its output fits on the screen, which would
+// return to the browser before the output could be read. git sets LESS to
+// "FRX" if it's unset, and F is what makes less exit early.
+func pager(cmd *exec.Cmd) *exec.Cmd {
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=R")
+	}
+	return cmd
+}
+
+// git runs a git command on the selected file, like staging it, and reloads
+// the listing to show its new status
+func (b *browser) git(args ...string) {
+	file := b.selectedFile()
+	if file == nil {
+		return
+	}
+	cmd := gitCommand(b.dir, append(args, file.Name())...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		b.message = fmt.Sprintf("git %s failed: %s", args[0], firstLine(out, err))
+		return
+	}
+	b.load(file.Name())
+}
+
+// firstLine returns the first line of a command's output, or the error if
+// there's no output
+func firstLine(out []byte, err error) string {
+	if line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n"); line != "" {
+		return line
+	}
+	return err.Error()
+}
+
+// interactive suspends the browser while cmd runs attached to the terminal,
+// so that editors and pagers work
+func (b *browser) interactive(cmd *exec.Cmd) {
+	b.stop()
+	defer func() {
+		if err := b.start(); err != nil {
+			b.message = err.Error()
+		}
+	}()
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// git diff --no-index exits with status 1 when there are
+		// differences, which isn't a failure
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			b.message = fmt.Sprintf("%s failed: %v", filepath.Base(cmd.Path), err)
+		}
+	}
+}
+
+// draw renders the listing, scrolled so that the selected file is visible
+func (b *browser) draw() {
+	width := columns(os.Stdout.Fd())
+	pageSize := b.pageSize()
+	if b.selected < b.offset {
+		b.offset = b.selected
+	}
+	if b.selected >= b.offset+pageSize {
+		b.offset = b.selected - pageSize + 1
+	}
+
+	// render the listing with room for the selection marker, then split it
+	// into one line per file
+	var listing bytes.Buffer
+	links := b.links
+	links.Width = width - 2
+	links.Icons = b.opts.icons
+	gitls.Render(&listing, b.files, links)
+	lines := strings.Split(strings.TrimSuffix(listing.String(), "\n"), "\n")
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	rel, err := filepath.Rel(filepath.Dir(b.root), b.dir)
+	if err != nil {
+		rel = b.dir
+	}
+	fmt.Fprintf(&screen, "On branch %s%s%s in %s\x1b[K\r\n\x1b[K\r\n", gitls.RED, b.branch, gitls.RESET, rel)
+
+	for i := b.offset; i < min(b.offset+pageSize, len(b.files)); i++ {
+		line := ""
+		if i < len(lines) {
+			line = lines[i]
+		}
+		if i == b.selected {
+			fmt.Fprintf(&screen, "%s>%s %s\x1b[K\r\n", gitls.YELLOW, gitls.RESET, line)
+		} else {
+			fmt.Fprintf(&screen, "  %s\x1b[K\r\n", line)
+		}
+	}
+	screen.WriteString("\x1b[J")
+
+	// the status line sits at the bottom of the screen
+	status := b.message
+	if status == "" {
+		status = "↑↓ move  enter open  ← up  e edit  s stage  u unstage  d diff  l log  r refresh  q quit"
+	}
+	fmt.Fprintf(&screen, "\x1b[%d;1H\x1b[7m%s\x1b[K\x1b[0m", rows(os.Stdout.Fd()), status)
+
+	os.Stdout.WriteString(screen.String())
+}
+
+// rawMode puts the terminal into raw mode, so that we receive each key as
+// it's pressed without it being echoed, and returns a function that restores
+// its previous state. Reads time out after a tenth of a second, so that the
+// caller can periodically check for other events.
+func rawMode(fd uintptr) (func(), error) {
+	var old syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(&old))); errno != 0 {
+		return nil, errno
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&raw))); errno != 0 {
+		return nil, errno
+	}
+
+	return func() {
+		_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
+
+// readKey reads a key press from stdin. ok is false if no key was pressed
+// before the read timed out.
+func readKey() (k int, ok bool) {
+	buf := make([]byte, 8)
+	n, err := syscall.Read(int(os.Stdin.Fd()), buf)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return parseKey(buf[:n]), true
+}
+
+// parseKey turns the bytes of a key press into a key. Keys like the arrows
+// are sent by the terminal as escape sequences.
+func parseKey(buf []byte) int {
+	if buf[0] != 0x1b {
+		return int(buf[0])
+	}
+	switch string(buf) {
+	case "\x1b":
+		return keyEscape
+	case "\x1b[A", "\x1bOA":
+		return keyUp
+	case "\x1b[B", "\x1bOB":
+		return keyDown
+	case "\x1b[C", "\x1bOC":
+		return keyRight
+	case "\x1b[D", "\x1bOD":
+		return keyLeft
+	case "\x1b[5~":
+		return keyPageUp
+	case "\x1b[6~":
+		return keyPageDown
+	case "\x1b[H", "\x1bOH", "\x1b[1~":
+		return keyHome
+	case "\x1b[F", "\x1bOF", "\x1b[4~":
+		return keyEnd
+	}
+	// ignore any other escape sequence
+	return 0
+}
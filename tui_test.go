@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{"Letter", "q", 'q'},
+		{"Enter", "\r", keyEnter},
+		{"Ctrl-C", "\x03", keyCtrlC},
+		{"Escape", "\x1b", keyEscape},
+		{"Up", "\x1b[A", keyUp},
+		{"Up in application mode", "\x1bOA", keyUp},
+		{"Down", "\x1b[B", keyDown},
+		{"Down in application mode", "\x1bOB", keyDown},
+		{"Right", "\x1b[C", keyRight},
+		{"Left", "\x1b[D", keyLeft},
+		{"Left in application mode", "\x1bOD", keyLeft},
+		{"Page up", "\x1b[5~", keyPageUp},
+		{"Page down", "\x1b[6~", keyPageDown},
+		{"Home", "\x1b[H", keyHome},
+		{"Home as a VT sequence", "\x1b[1~", keyHome},
+		{"End", "\x1b[F", keyEnd},
+		{"End as a VT sequence", "\x1b[4~", keyEnd},
+		{"Unknown sequence", "\x1b[15~", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if k := parseKey([]byte(tc.input)); k != tc.expected {
+				t.Errorf("parseKey(%q) = %d, expected %d", tc.input, k, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/llimllib/git-ls/gitls"
+)
+
+// watchTarget is a directory to watch for changes. If recursive is true, its
+// subdirectories are watched too, except for those in skip. If names is not
+// nil, only changes to files with those names are reported.
+type watchTarget struct {
+	dir       string
+	recursive bool
+	skip      map[string]bool
+	names     map[string]bool
+}
+
+// relevant returns true if a change to the file name within the target
+// should cause a redraw. Lock files are created and removed by git as it
+// works, so they're always ignored; the change that matters is the rename of
+// the lock file over the real one.
+func (t *watchTarget) relevant(name string) bool {
+	if strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	return t.names == nil || t.names[name]
+}
+
+// subdirs returns dir and all of the directories below it that should be
+// watched
+func (t *watchTarget) subdirs(dir string) []string {
+	dirs := []string{dir}
+	if !t.recursive {
+		return dirs
+	}
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == dir {
+			return nil
+		}
+		if d.Name() == ".git" || t.skip[path] {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs
+}
+
+// watchTargets returns the directories to watch to notice any change that
+// would alter the listing: the listed directories themselves, and the files
+// in the git directory that change when the index, HEAD or a branch change.
+func watchTargets(fileOperands, dirOperands []string) ([]*watchTarget, error) {
+	var targets []*watchTarget
+	var repos []string
+	for _, operand := range fileOperands {
+		dir, err := filepath.Abs(filepath.Dir(operand))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &watchTarget{dir: dir})
+		repos = append(repos, dir)
+	}
+	for _, operand := range dirOperands {
+		dir, err := filepath.Abs(operand)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &watchTarget{
+			dir:       dir,
+			recursive: true,
+			skip:      ignoredDirs(dir),
+		})
+		repos = append(repos, dir)
+	}
+
+	seen := make(map[string]bool)
+	for _, dir := range repos {
+		gitDir, err := gitls.GitDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		commonDir, err := gitls.CommonDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		if seen[gitDir] {
+			continue
+		}
+		seen[gitDir] = true
+		targets = append(targets,
+			&watchTarget{
+				dir:   gitDir,
+				names: map[string]bool{"index": true, "HEAD": true, "packed-refs": true},
+			},
+			&watchTarget{
+				dir:       filepath.Join(commonDir, "refs", "heads"),
+				recursive: true,
+			})
+	}
+	return targets, nil
+}
+
+// ignoredDirs returns the set of directories within dir that git ignores, so
+// that we don't watch large directories of build output or dependencies
+func ignoredDirs(dir string) map[string]bool {
+	cmd := gitCommand(dir, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	ignored := make(map[string]bool)
+	for _, path := range strings.Split(string(out), "\x00") {
+		if strings.HasSuffix(path, "/") {
+			ignored[filepath.Join(dir, path)] = true
+		}
+	}
+	return ignored
+}
+
+// watchDebounce is how long to wait after a change for any others to arrive
+// before redrawing, since a single git operation usually touches many files
+const watchDebounce = 100 * time.Millisecond
+
+// watch renders the listing, then redraws it in place whenever something
+// changes, until the process is interrupted
+func watch(fileOperands, dirOperands []string, opts options) error {
+	// our own git commands shouldn't refresh the index, or the index changing
+	// would cause a redraw that refreshes the index again
+	os.Setenv("GIT_OPTIONAL_LOCKS", "0")
+
+	targets, err := watchTargets(fileOperands, dirOperands)
+	if err != nil {
+		return err
+	}
+	changes, err := watchChanges(targets)
+	if err != nil {
+		return err
+	}
+
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+
+	for {
+		var listing bytes.Buffer
+		if err := render(&listing, columns(os.Stdout.Fd()), fileOperands, dirOperands, opts); err != nil {
+			fmt.Fprintf(&listing, "git-ls: %v\n", err)
+		}
+		// move to the top left and clear the screen before drawing
+		os.Stdout.WriteString("\x1b[H\x1b[2J" + listing.String())
+
+		select {
+		case <-changes:
+		case <-resize:
+		}
+
+		settle := time.After(watchDebounce)
+	drain:
+		for {
+			select {
+			case <-changes:
+			case <-resize:
+			case <-settle:
+				break drain
+			}
+		}
+	}
+}
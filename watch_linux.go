@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events that indicate a change
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher tracks the directory and target for each inotify watch
+// descriptor
+type inotifyWatcher struct {
+	fd      int
+	dirs    map[int32]string
+	targets map[int32]*watchTarget
+	changes chan struct{}
+}
+
+// watchChanges uses inotify to watch the targets, and returns a channel which
+// receives a value whenever one of them changes
+func watchChanges(targets []*watchTarget) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	w := &inotifyWatcher{
+		fd:      fd,
+		dirs:    make(map[int32]string),
+		targets: make(map[int32]*watchTarget),
+		changes: make(chan struct{}, 1),
+	}
+	for _, target := range targets {
+		if err := w.add(target.dir, target); err != nil {
+			return nil, fmt.Errorf("failed to watch %s: %w", target.dir, err)
+		}
+		// subdirectories are watched on a best-effort basis, since there's a
+		// limit on the number of watches a user can have
+		for _, dir := range target.subdirs(target.dir)[1:] {
+			_ = w.add(dir, target)
+		}
+	}
+
+	go w.read()
+	return w.changes, nil
+}
+
+func (w *inotifyWatcher) add(dir string, target *watchTarget) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.dirs[int32(wd)] = dir
+	w.targets[int32(wd)] = target
+	return nil
+}
+
+// read reads inotify events forever, adding watches for new directories and
+// reporting relevant changes
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			name := strings.TrimRight(string(buf[start:offset]), "\x00")
+
+			// if events were dropped, we don't know what changed
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.notify()
+				continue
+			}
+
+			target, ok := w.targets[event.Wd]
+			if !ok {
+				continue
+			}
+
+			path := filepath.Join(w.dirs[event.Wd], name)
+			isNewDir := event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0
+			if isNewDir && target.recursive && name != ".git" && !target.skip[path] {
+				for _, dir := range target.subdirs(path) {
+					_ = w.add(dir, target)
+				}
+			}
+
+			if target.relevant(name) {
+				w.notify()
+			}
+		}
+	}
+}
+
+// notify reports a change without blocking; if there's already a change
+// waiting to be handled, there's no need to report another
+func (w *inotifyWatcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
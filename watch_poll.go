@@ -0,0 +1,54 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+)
+
+// pollInterval is how often the targets are checked for changes
+const pollInterval = 500 * time.Millisecond
+
+// watchChanges polls the targets for changes on platforms without inotify,
+// and returns a channel which receives a value whenever one of them changes
+func watchChanges(targets []*watchTarget) (<-chan struct{}, error) {
+	changes := make(chan struct{}, 1)
+	go func() {
+		last := fingerprint(targets)
+		for range time.Tick(pollInterval) {
+			if current := fingerprint(targets); current != last {
+				last = current
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// fingerprint returns a hash of the names, sizes, modes and modification
+// times of the relevant files in the targets
+func fingerprint(targets []*watchTarget) uint64 {
+	h := fnv.New64a()
+	for _, target := range targets {
+		for _, dir := range target.subdirs(target.dir) {
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				if !target.relevant(entry.Name()) {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(h, "%s/%s %d %d %v\n", dir, entry.Name(), info.Size(), info.ModTime().UnixNano(), info.Mode())
+			}
+		}
+	}
+	return h.Sum64()
+}
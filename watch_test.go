@@ -0,0 +1,94 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelevant(t *testing.T) {
+	all := &watchTarget{dir: "/src"}
+	named := &watchTarget{dir: "/src/.git", names: map[string]bool{"index": true, "HEAD": true}}
+
+	testCases := []struct {
+		name     string
+		target   *watchTarget
+		file     string
+		expected bool
+	}{
+		{"Any file", all, "main.go", true},
+		{"Lock file", all, "main.go.lock", false},
+		{"Named file", named, "index", true},
+		{"Other file", named, "config", false},
+		{"Named file's lock file", named, "index.lock", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.target.relevant(tc.file); actual != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWatchTargets(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(testRepos(t, "one", "two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	gitFiles := map[string]bool{"index": true, "HEAD": true, "packed-refs": true}
+
+	type target struct {
+		dir       string
+		recursive bool
+		names     map[string]bool
+	}
+	testCases := []struct {
+		name         string
+		fileOperands []string
+		dirOperands  []string
+		expected     []target
+	}{
+		{
+			name:         "One repository",
+			fileOperands: []string{path("one/f.txt")},
+			dirOperands:  []string{path("one/d")},
+			expected: []target{
+				{dir: path("one")},
+				{dir: path("one/d"), recursive: true},
+				{dir: path("one/.git"), names: gitFiles},
+				{dir: path("one/.git/refs/heads"), recursive: true},
+			},
+		},
+		{
+			name:        "Two repositories",
+			dirOperands: []string{path("one"), path("two/d")},
+			expected: []target{
+				{dir: path("one"), recursive: true},
+				{dir: path("two/d"), recursive: true},
+				{dir: path("one/.git"), names: gitFiles},
+				{dir: path("one/.git/refs/heads"), recursive: true},
+				{dir: path("two/.git"), names: gitFiles},
+				{dir: path("two/.git/refs/heads"), recursive: true},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			targets, err := watchTargets(tc.fileOperands, tc.dirOperands)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(targets) != len(tc.expected) {
+				t.Fatalf("Expected %d targets, got %d", len(tc.expected), len(targets))
+			}
+			for i, expected := range tc.expected {
+				actual := targets[i]
+				if actual.dir != expected.dir || actual.recursive != expected.recursive || !maps.Equal(actual.names, expected.names) {
+					t.Errorf("Expected target %d to be %+v, got %+v", i, expected, *actual)
+				}
+			}
+		})
+	}
+}